@@ -0,0 +1,284 @@
+// ConcurrentHashMap is a thread-safe HashMap using lock striping.
+// Keys are sharded by the high bits of their hash over a number of
+// independently locked HashMaps, which use the low bits to select
+// their buckets and grow and shrink on their own.
+package esMap
+
+import (
+	"errors"
+	"math/bits"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+// segment is a locked HashMap, padded so that neighbouring
+// segments do not share cache lines.
+type segment struct {
+	sync.Mutex
+	HashMap
+	_ [_SEGPAD - unsafe.Sizeof(segmentData{})%_SEGPAD]byte
+}
+
+type segmentData struct {
+	sync.Mutex
+	HashMap
+}
+
+// _SEGPAD is the size segments are padded to, two cache lines.
+const _SEGPAD = 128
+
+// ConcurrentHashMap stores Entry items in locked HashMap segments
+// using a given Hash function. The Hash function can be overridden
+// before the map is used. Hash options such as WithMapHash apply to
+// the whole map, but shards never switch to a keyed hash on their own
+// when flooded with collisions, so use WithMapHash for untrusted keys.
+type ConcurrentHashMap struct {
+	Hash func([]byte) uint32
+	// Hash64 switches the map to 64 bit hashes when set, see
+	// WithHasher64.
+	Hash64 func([]byte) uint64
+	segs   []segment
+	shift  uint32
+}
+
+// NewConcurrentHashMapWithShards creates a new ConcurrentHashMap
+// with the given number of shards, each a HashMap configured by opts.
+// shards must be a power of 2.
+func NewConcurrentHashMapWithShards(shards int, opts ...Option) (*ConcurrentHashMap, error) {
+	if shards <= 0 || (shards&(shards-1) != 0) {
+		return nil, errors.New("Number of shards must be power of 2")
+	}
+	c := ConcurrentHashMap{}
+	c.Hash = DefaultHash
+	c.segs = make([]segment, shards)
+	c.shift = uint32(32 - bits.TrailingZeros(uint(shards)))
+	for i := range c.segs {
+		s := &c.segs[i]
+		s.init(make([]*Entry, _BSZ), opts)
+		// Shards are routed by c.Hash, so they all share it and
+		// must never switch to a keyed hash of their own.
+		if i == 0 {
+			c.Hash, c.Hash64 = s.Hash, s.Hash64
+		}
+		s.Hash, s.Hash64 = c.Hash, c.Hash64
+		s.dosAt = 0
+	}
+	return &c, nil
+}
+
+// NewConcurrentHashMap creates a new ConcurrentHashMap with a number
+// of shards fitting GOMAXPROCS.
+func NewConcurrentHashMap(opts ...Option) *ConcurrentHashMap {
+	n := 1 << bits.Len(uint(runtime.GOMAXPROCS(0)*4-1))
+	c, _ := NewConcurrentHashMapWithShards(n, opts...)
+	return c
+}
+
+// hash returns the hash of key, of 64 bits with Hash64 or 32 bits.
+func (c *ConcurrentHashMap) hash(key []byte) uint64 {
+	if c.Hash64 != nil {
+		return c.Hash64(key)
+	}
+	return uint64(c.Hash(key))
+}
+
+// segment returns the segment owning the hash hk.
+func (c *ConcurrentHashMap) segment(hk uint64) *segment {
+	if c.Hash64 != nil {
+		hk >>= 32
+	}
+	return &c.segs[hk>>c.shift&uint64(len(c.segs)-1)]
+}
+
+// Set will set the key item to data. This will blindly replace any item
+// that may have been at key previous.
+func (c *ConcurrentHashMap) Set(key []byte, data interface{}) {
+	hk := c.hash(key)
+	s := c.segment(hk)
+	s.Lock()
+	s.set(hk, key, data)
+	s.Unlock()
+}
+
+// Get will return the item at key.
+func (c *ConcurrentHashMap) Get(key []byte) interface{} {
+	hk := c.hash(key)
+	s := c.segment(hk)
+	s.Lock()
+	e := s.get(hk, key)
+	var data interface{}
+	if e != nil {
+		data = e.data
+	}
+	s.Unlock()
+	return data
+}
+
+// Lookup will return the item at key and whether it was found.
+func (c *ConcurrentHashMap) Lookup(key []byte) (interface{}, bool) {
+	hk := c.hash(key)
+	s := c.segment(hk)
+	s.Lock()
+	e := s.get(hk, key)
+	var data interface{}
+	if e != nil {
+		data = e.data
+	}
+	s.Unlock()
+	return data, e != nil
+}
+
+// Contains reports whether key is present in the ConcurrentHashMap.
+func (c *ConcurrentHashMap) Contains(key []byte) bool {
+	_, ok := c.Lookup(key)
+	return ok
+}
+
+// Remove will remove what is associated with key. It returns the
+// removed item and whether anything was removed.
+func (c *ConcurrentHashMap) Remove(key []byte) (interface{}, bool) {
+	hk := c.hash(key)
+	s := c.segment(hk)
+	s.Lock()
+	data, ok := s.remove(hk, key)
+	s.Unlock()
+	return data, ok
+}
+
+// GetOrSet is HashMap.GetOrSet done atomically.
+func (c *ConcurrentHashMap) GetOrSet(key []byte, data interface{}) (actual interface{}, loaded bool) {
+	hk := c.hash(key)
+	s := c.segment(hk)
+	s.Lock()
+	defer s.Unlock()
+	return s.getOrSet(hk, key, data)
+}
+
+// SetIfAbsent is HashMap.SetIfAbsent done atomically.
+func (c *ConcurrentHashMap) SetIfAbsent(key []byte, data interface{}) bool {
+	_, loaded := c.GetOrSet(key, data)
+	return !loaded
+}
+
+// Swap is HashMap.Swap done atomically.
+func (c *ConcurrentHashMap) Swap(key []byte, data interface{}) (previous interface{}, loaded bool) {
+	hk := c.hash(key)
+	s := c.segment(hk)
+	s.Lock()
+	defer s.Unlock()
+	return s.swap(hk, key, data)
+}
+
+// CompareAndSwap is HashMap.CompareAndSwap done atomically.
+func (c *ConcurrentHashMap) CompareAndSwap(key []byte, old, data interface{}) bool {
+	hk := c.hash(key)
+	s := c.segment(hk)
+	s.Lock()
+	defer s.Unlock()
+	return s.compareAndSwap(hk, key, old, data)
+}
+
+// CompareAndDelete is HashMap.CompareAndDelete done atomically.
+func (c *ConcurrentHashMap) CompareAndDelete(key []byte, old interface{}) bool {
+	hk := c.hash(key)
+	s := c.segment(hk)
+	s.Lock()
+	defer s.Unlock()
+	return s.compareAndDelete(hk, key, old)
+}
+
+// Update is HashMap.Update done atomically. fn runs with the shard
+// of key locked, so it must be quick and must not use the map.
+func (c *ConcurrentHashMap) Update(key []byte, fn UpdateFunc) (interface{}, bool) {
+	hk := c.hash(key)
+	s := c.segment(hk)
+	s.Lock()
+	defer s.Unlock()
+	return s.update(hk, key, fn)
+}
+
+// Count returns number of elements in the ConcurrentHashMap. With
+// concurrent writers this is a snapshot taken one shard at a time.
+func (c *ConcurrentHashMap) Count() uint32 {
+	var n uint32
+	for i := range c.segs {
+		s := &c.segs[i]
+		s.Lock()
+		n += s.used
+		s.Unlock()
+	}
+	return n
+}
+
+// Reserve makes room for n elements, spread evenly over the shards.
+func (c *ConcurrentHashMap) Reserve(n int) {
+	per := (n + len(c.segs) - 1) / len(c.segs)
+	for i := range c.segs {
+		s := &c.segs[i]
+		s.Lock()
+		s.Reserve(per)
+		s.Unlock()
+	}
+}
+
+// Clear removes all elements, one shard at a time.
+func (c *ConcurrentHashMap) Clear() {
+	for i := range c.segs {
+		s := &c.segs[i]
+		s.Lock()
+		s.Clear()
+		s.Unlock()
+	}
+}
+
+// AllKeys will return all the keys stored in the ConcurrentHashMap
+func (c *ConcurrentHashMap) AllKeys() [][]byte {
+	var all [][]byte
+	for i := range c.segs {
+		s := &c.segs[i]
+		s.Lock()
+		all = append(all, s.AllKeys()...)
+		s.Unlock()
+	}
+	return all
+}
+
+// All returns all the Entries in the ConcurrentHashMap
+func (c *ConcurrentHashMap) All() []interface{} {
+	var all []interface{}
+	for i := range c.segs {
+		s := &c.segs[i]
+		s.Lock()
+		all = append(all, s.All()...)
+		s.Unlock()
+	}
+	return all
+}
+
+// ShardStats will collect the statistics of every shard.
+func (c *ConcurrentHashMap) ShardStats() []*Stats {
+	all := make([]*Stats, len(c.segs))
+	for i := range c.segs {
+		s := &c.segs[i]
+		s.Lock()
+		all[i] = s.Stats()
+		s.Unlock()
+	}
+	return all
+}
+
+// Stats will collect general statistics aggregated over all shards.
+func (c *ConcurrentHashMap) Stats() *Stats {
+	st := &Stats{}
+	for _, s := range c.ShardStats() {
+		st.NumElements += s.NumElements
+		st.NumSlots += s.NumSlots
+		st.NumBuckets += s.NumBuckets
+		if s.LongChain > st.LongChain {
+			st.LongChain = s.LongChain
+		}
+	}
+	st.AvgChain = float32(st.NumElements) / float32(st.NumSlots)
+	return st
+}
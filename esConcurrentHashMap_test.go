@@ -0,0 +1,170 @@
+package esMap
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentHashMapWithShards(t *testing.T) {
+	if _, err := NewConcurrentHashMapWithShards(3); err == nil {
+		t.Fatalf("Shards of %d should have failed\n", 3)
+	}
+	if _, err := NewConcurrentHashMapWithShards(0); err == nil {
+		t.Fatalf("Shards of %d should have failed\n", 0)
+	}
+	for _, n := range []int{1, 2, 16} {
+		c, err := NewConcurrentHashMapWithShards(n)
+		if err != nil {
+			t.Fatalf("Shards of %d should have succeeded\n", n)
+		}
+		c.Set(foo, bar)
+		if v, ok := c.Lookup(foo); !ok || string(v.([]byte)) != string(bar) {
+			t.Fatalf("Did not receive correct answer: '%s' vs '%v'\n", bar, v)
+		}
+	}
+}
+
+func TestConcurrentHashMapBasics(t *testing.T) {
+	c := NewConcurrentHashMap()
+	c.Set(foo, bar)
+	c.Set(baz, nil)
+	if c.Count() != 2 {
+		t.Fatalf("Wrong number of entries: %d vs 2\n", c.Count())
+	}
+	if v := c.Get(foo); v == nil || string(v.([]byte)) != string(bar) {
+		t.Fatalf("Did not receive correct answer: '%s' vs '%v'\n", bar, v)
+	}
+	if !c.Contains(baz) || c.Contains(bar) {
+		t.Fatalf("Contains gave the wrong answer\n")
+	}
+	if len(c.AllKeys()) != 2 || len(c.All()) != 2 {
+		t.Fatalf("Expected 2 keys and values\n")
+	}
+	if _, ok := c.Remove(foo); !ok {
+		t.Fatalf("Expected foo to be removed\n")
+	}
+	if v := c.Get(foo); v != nil {
+		t.Fatal("Did not receive correct answer, should be nil")
+	}
+}
+
+func TestConcurrentHashMapShards(t *testing.T) {
+	c, _ := NewConcurrentHashMapWithShards(8)
+	n := 8 * 1024
+	for i := 0; i < n; i++ {
+		c.Set([]byte(fmt.Sprintf("foo.%d", i)), i)
+	}
+	ss := c.ShardStats()
+	for i, s := range ss {
+		// Every shard grows on its own
+		if s.NumElements == 0 || s.NumBuckets < 128 {
+			t.Fatalf("Shard %d did not get its share: %+v\n", i, s)
+		}
+	}
+	s := c.Stats()
+	if s.NumElements != uint32(n) {
+		t.Fatalf("NumElements incorrect: %d vs %d\n", s.NumElements, n)
+	}
+	if s.NumBuckets < uint32(n)/2 {
+		t.Fatalf("NumBuckets too small: %d\n", s.NumBuckets)
+	}
+	for i := 0; i < n; i++ {
+		c.Remove([]byte(fmt.Sprintf("foo.%d", i)))
+	}
+	for i, s := range c.ShardStats() {
+		if s.NumElements != 0 || s.NumBuckets != _BSZ {
+			t.Fatalf("Shard %d did not shrink: %+v\n", i, s)
+		}
+	}
+}
+
+// Run with -race to check the locking.
+func TestConcurrentHashMapParallel(t *testing.T) {
+	c := NewConcurrentHashMap()
+	const workers, n = 8, 2000
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			keys := make([][]byte, n)
+			for i := range keys {
+				keys[i] = []byte(fmt.Sprintf("%d.foo.%d", w, i))
+				c.Set(keys[i], i)
+			}
+			for i, k := range keys {
+				if v, ok := c.Lookup(k); !ok || v.(int) != i {
+					t.Errorf("Lookup('%s') = %v, %v vs %d\n", k, v, ok, i)
+					return
+				}
+			}
+			c.Count()
+			c.Stats()
+			for _, k := range keys[n/2:] {
+				c.Remove(k)
+			}
+		}(w)
+	}
+	wg.Wait()
+	if c.Count() != workers*n/2 {
+		t.Fatalf("Wrong number of entries: %d vs %d\n", c.Count(), workers*n/2)
+	}
+}
+
+// lockedHashMap is how HashMap has been shared so far.
+type lockedHashMap struct {
+	sync.Mutex
+	*HashMap
+}
+
+func parallelKeys(size int) [][]byte {
+	keys := make([][]byte, size)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("foo.%d", i))
+	}
+	return keys
+}
+
+// benchmarkParallel runs a mix of 1 Set per every 9 Gets.
+func benchmarkParallel(b *testing.B, set func([]byte), get func([]byte)) {
+	keys := parallelKeys(8192)
+	for _, k := range keys {
+		set(k)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			k := keys[i&(len(keys)-1)]
+			if i%10 == 0 {
+				set(k)
+			} else {
+				get(k)
+			}
+			i += 7
+		}
+	})
+}
+
+func Benchmark_Parallel_LockedHashMap(b *testing.B) {
+	m := lockedHashMap{HashMap: NewHashMap()}
+	benchmarkParallel(b,
+		func(k []byte) { m.Lock(); m.Set(k, boxed); m.Unlock() },
+		func(k []byte) { m.Lock(); m.Get(k); m.Unlock() })
+}
+
+func Benchmark_Parallel_ConcurrentMap(b *testing.B) {
+	c := NewConcurrentHashMap()
+	benchmarkParallel(b,
+		func(k []byte) { c.Set(k, boxed) },
+		func(k []byte) { c.Get(k) })
+}
+
+func Benchmark_Parallel_SyncMap______(b *testing.B) {
+	var m sync.Map
+	benchmarkParallel(b,
+		func(k []byte) { m.Store(string(k), boxed) },
+		func(k []byte) { m.Load(string(k)) })
+}
@@ -0,0 +1,360 @@
+// CuckooMap is a bucketized cuckoo hashmap. Every key has two
+// candidate buckets of 4 slots, chosen by two hash functions derived
+// from Hash with different seeds, plus a small stash for the entries
+// that could not be placed. A lookup therefore compares at most
+// 2*4 keys plus the stash, whatever the load of the map.
+//
+// Keys sharing one hash share their buckets whatever the seeds, so
+// more of them than the buckets and the stash hold cannot be placed.
+// When new seeds keep failing the map switches to a keyed 64 bit
+// hash of the keys instead of growing.
+package esMap
+
+import (
+	"errors"
+	"math/rand"
+
+	"github.com/yireyun/go-map/hash"
+)
+
+const (
+	_CWAY     = 4   // slots per bucket
+	_CSTASH   = 4   // entries the stash holds before a rehash
+	_CKICKS   = 256 // evictions tried before using the stash
+	_CREHASH  = 4   // rehashes with new seeds before rekeying or growing
+	_CLOAD    = 9   // maximum load factor, in tenths
+	_CMINBKTS = 2   // minimum number of buckets
+	_CMAXBKTS = 1 << 28
+)
+
+// cuckooSlot is the Entry of a CuckooMap.
+type cuckooSlot struct {
+	hk   uint64
+	full bool
+	key  []byte
+	data interface{}
+}
+
+// CuckooMap stores cuckooSlot items using a given Hash function.
+// The Hash function can be overridden before the map is used.
+type CuckooMap struct {
+	Hash  func([]byte) uint32
+	bkts  [][_CWAY]cuckooSlot
+	msk   uint32
+	seeds [2]uint64
+	// keyed replaces Hash once the map switched to a keyed hash.
+	keyed func([]byte) uint64
+	stash []cuckooSlot
+	used  uint32
+	rnd   uint32 // xorshift state for picking victims
+	// rehashes counts rehashes forced by failed insertions.
+	rehashes uint32
+}
+
+// NewCuckooMapWithBkts creates a new CuckooMap with bkts buckets of
+// 4 slots each. bkts must be a power of 2 of at least 2.
+func NewCuckooMapWithBkts(bkts int) (*CuckooMap, error) {
+	if bkts < _CMINBKTS || (bkts&(bkts-1) != 0) {
+		return nil, errors.New("Size of buckets must be power of 2 of at least 2")
+	}
+	h := &CuckooMap{Hash: DefaultHash, rnd: rand.Uint32() | 1}
+	h.alloc(bkts)
+	h.reseed()
+	return h, nil
+}
+
+// NewCuckooMap creates a new CuckooMap of default size and using the
+// default Hashing algorithm.
+func NewCuckooMap() *CuckooMap {
+	h, _ := NewCuckooMapWithBkts(_BSZ / _CWAY)
+	return h
+}
+
+func (h *CuckooMap) alloc(n int) {
+	h.bkts = make([][_CWAY]cuckooSlot, n)
+	h.msk = uint32(n - 1)
+}
+
+func (h *CuckooMap) reseed() {
+	h.seeds[0], h.seeds[1] = rand.Uint64(), rand.Uint64()
+}
+
+// hash returns the hash of key, of 64 bits once keyed or 32 bits.
+func (h *CuckooMap) hash(key []byte) uint64 {
+	if h.keyed != nil {
+		return h.keyed(key)
+	}
+	return uint64(h.Hash(key))
+}
+
+// buckets returns the two candidate buckets of hash hk.
+func (h *CuckooMap) buckets(hk uint64) (uint32, uint32) {
+	b1 := uint32(mix64(hk^h.seeds[0])) & h.msk
+	b2 := uint32(mix64(hk^h.seeds[1])) & h.msk
+	if b1 == b2 {
+		b2 ^= 1
+	}
+	return b1, b2
+}
+
+// find returns the slot holding key with hash hk, or nil.
+func (h *CuckooMap) find(hk uint64, key []byte) *cuckooSlot {
+	b1, b2 := h.buckets(hk)
+	for _, b := range [2]uint32{b1, b2} {
+		bkt := &h.bkts[b]
+		for i := range bkt {
+			if e := &bkt[i]; e.full && e.hk == hk && SilceEqui(key, e.key) {
+				return e
+			}
+		}
+	}
+	for i := range h.stash {
+		if e := &h.stash[i]; e.hk == hk && SilceEqui(key, e.key) {
+			return e
+		}
+	}
+	return nil
+}
+
+// Set will set the key item to data. This will blindly replace any item
+// that may have been at key previous.
+func (h *CuckooMap) Set(key []byte, data interface{}) {
+	hk := h.hash(key)
+	if e := h.find(hk, key); e != nil {
+		// Success, replace data field
+		e.data = data
+		return
+	}
+	// We have a new entry here
+	n := len(h.bkts)
+	if h.used >= uint32(n*_CWAY*_CLOAD/10) && n < _CMAXBKTS {
+		h.rehash(n<<1, nil)
+	}
+	h.used++
+	if homeless, ok := h.place(cuckooSlot{hk: hk, full: true, key: key, data: data}, _CSTASH); !ok {
+		h.rehashes++
+		h.rehash(len(h.bkts), &homeless)
+	}
+}
+
+// next returns a pseudo random number for picking victims.
+func (h *CuckooMap) next() uint32 {
+	h.rnd ^= h.rnd << 13
+	h.rnd ^= h.rnd >> 17
+	h.rnd ^= h.rnd << 5
+	return h.rnd
+}
+
+// place puts ne into one of its buckets, evicting entries to their
+// alternate bucket as needed, or into the stash while it holds less
+// than stash entries. Otherwise it gives up and returns the entry
+// left without a slot, which is not necessarily ne.
+func (h *CuckooMap) place(ne cuckooSlot, stash int) (cuckooSlot, bool) {
+	b1, b2 := h.buckets(ne.hk)
+	for _, b := range [2]uint32{b1, b2} {
+		bkt := &h.bkts[b]
+		for i := range bkt {
+			if !bkt[i].full {
+				bkt[i] = ne
+				return cuckooSlot{}, true
+			}
+		}
+	}
+	// Both buckets are full, kick out a random victim and
+	// move it to its other bucket, and so on.
+	b := b1
+	if h.next()&1 != 0 {
+		b = b2
+	}
+	for k := 0; k < _CKICKS; k++ {
+		bkt := &h.bkts[b]
+		i := h.next() % _CWAY
+		ne, bkt[i] = bkt[i], ne
+		v1, v2 := h.buckets(ne.hk)
+		if b == v1 {
+			b = v2
+		} else {
+			b = v1
+		}
+		bkt = &h.bkts[b]
+		for i := range bkt {
+			if !bkt[i].full {
+				bkt[i] = ne
+				return cuckooSlot{}, true
+			}
+		}
+	}
+	if len(h.stash) < stash {
+		h.stash = append(h.stash, ne)
+		return cuckooSlot{}, true
+	}
+	return ne, false
+}
+
+// rehash moves all entries, and extra if not nil, into n buckets.
+// When an entry cannot be placed it tries new seeds. If _CREHASH
+// of them fail, the map switches to a keyed hash first, and doubles
+// the buckets every _CREHASH failures after that. Only at _CMAXBKTS
+// buckets does the stash grow beyond _CSTASH.
+func (h *CuckooMap) rehash(n int, extra *cuckooSlot) {
+	all := make([]cuckooSlot, 0, h.used)
+	for b := range h.bkts {
+		for i := range h.bkts[b] {
+			if h.bkts[b][i].full {
+				all = append(all, h.bkts[b][i])
+			}
+		}
+	}
+	all = append(all, h.stash...)
+	if extra != nil {
+		all = append(all, *extra)
+	}
+	for attempt := 1; !h.fill(n, all, _CSTASH); attempt++ {
+		h.reseed()
+		if attempt%_CREHASH != 0 {
+			continue
+		}
+		switch {
+		case h.keyed == nil:
+			// Keys sharing a hash fail with any seeds and more
+			// buckets would not help, a keyed hash separates them.
+			h.rekey(all)
+		case n < _CMAXBKTS:
+			n <<= 1
+		default:
+			h.fill(n, all, len(all))
+			return
+		}
+	}
+}
+
+// fill places all entries into n empty buckets with a stash of at
+// most stash entries, and reports whether every entry found a slot.
+func (h *CuckooMap) fill(n int, all []cuckooSlot, stash int) bool {
+	h.alloc(n)
+	h.stash = nil
+	for _, e := range all {
+		if _, placed := h.place(e, stash); !placed {
+			return false
+		}
+	}
+	return true
+}
+
+// rekey switches the CuckooMap to a keyed 64 bit hash with a random
+// seed of its own and rehashes the entries all.
+func (h *CuckooMap) rekey(all []cuckooSlot) {
+	h.keyed = hash.NewMapHasher().Sum64
+	for i := range all {
+		all[i].hk = h.keyed(all[i].key)
+	}
+}
+
+// Get will return the item at key.
+func (h *CuckooMap) Get(key []byte) interface{} {
+	if e := h.find(h.hash(key), key); e != nil {
+		return e.data
+	}
+	return nil
+}
+
+// Lookup will return the item at key and whether it was found.
+func (h *CuckooMap) Lookup(key []byte) (interface{}, bool) {
+	if e := h.find(h.hash(key), key); e != nil {
+		return e.data, true
+	}
+	return nil, false
+}
+
+// Contains reports whether key is present in the CuckooMap.
+func (h *CuckooMap) Contains(key []byte) bool {
+	return h.find(h.hash(key), key) != nil
+}
+
+// Remove will remove what is associated with key. It returns the
+// removed item and whether anything was removed.
+func (h *CuckooMap) Remove(key []byte) (interface{}, bool) {
+	hk := h.hash(key)
+	e := h.find(hk, key)
+	if e == nil {
+		return nil, false
+	}
+	data := e.data
+	*e = cuckooSlot{}
+	for i := range h.stash {
+		if &h.stash[i] == e {
+			last := len(h.stash) - 1
+			h.stash[i] = h.stash[last]
+			h.stash[last] = cuckooSlot{}
+			h.stash = h.stash[:last]
+			break
+		}
+	}
+	h.used--
+	// Check for resizing
+	if n := len(h.bkts); n > _CMINBKTS && h.used < uint32(n*_CWAY/8) {
+		h.rehash(n>>1, nil)
+	}
+	return data, true
+}
+
+// Count returns number of elements in the CuckooMap
+func (h *CuckooMap) Count() uint32 {
+	return h.used
+}
+
+// each calls fn with every entry and its probe distance:
+// 0 in its first bucket, 1 in its second and 2 in the stash.
+func (h *CuckooMap) each(fn func(e *cuckooSlot, d uint32)) {
+	for b := range h.bkts {
+		for i := range h.bkts[b] {
+			if e := &h.bkts[b][i]; e.full {
+				d := uint32(1)
+				if b1, _ := h.buckets(e.hk); b1 == uint32(b) {
+					d = 0
+				}
+				fn(e, d)
+			}
+		}
+	}
+	for i := range h.stash {
+		fn(&h.stash[i], 2)
+	}
+}
+
+// AllKeys will return all the keys stored in the CuckooMap
+func (h *CuckooMap) AllKeys() [][]byte {
+	all := make([][]byte, 0, h.used)
+	h.each(func(e *cuckooSlot, _ uint32) { all = append(all, e.key) })
+	return all
+}
+
+// All returns all the Entries in the CuckooMap
+func (h *CuckooMap) All() []interface{} {
+	all := make([]interface{}, 0, h.used)
+	h.each(func(e *cuckooSlot, _ uint32) { all = append(all, e.data) })
+	return all
+}
+
+// Stats will collect general statistics about the CuckooMap. The
+// probe distance is 0 for entries in their first bucket, 1 in their
+// second bucket and 2 in the stash.
+func (h *CuckooMap) Stats() *Stats {
+	var mp, total uint32
+	h.each(func(e *cuckooSlot, d uint32) {
+		total += d
+		if d > mp {
+			mp = d
+		}
+	})
+	s := &Stats{
+		NumElements: h.used,
+		NumSlots:    h.used,
+		NumBuckets:  uint32(len(h.bkts)),
+		MaxProbe:    mp,
+	}
+	if h.used > 0 {
+		s.AvgProbe = float32(total) / float32(h.used)
+	}
+	return s
+}
@@ -0,0 +1,200 @@
+package esMap
+
+import (
+	"fmt"
+	mrand "math/rand"
+	"testing"
+)
+
+func TestCuckooMapBasics(t *testing.T) {
+	for _, n := range []int{0, 1, 3} {
+		if _, err := NewCuckooMapWithBkts(n); err == nil {
+			t.Fatalf("Buckets size of %d should have failed\n", n)
+		}
+	}
+	h := NewCuckooMap()
+	h.Set(foo, bar)
+	h.Set(baz, nil)
+	h.Set([]byte{}, "empty")
+	if h.Count() != 3 {
+		t.Fatalf("Wrong number of entries: %d vs 3\n", h.Count())
+	}
+	if v := h.Get(foo); v == nil || string(v.([]byte)) != string(bar) {
+		t.Fatalf("Did not receive correct answer: '%s' vs '%v'\n", bar, v)
+	}
+	if v, ok := h.Lookup(baz); !ok || v != nil {
+		t.Fatalf("Expected stored nil, got %v, %v\n", v, ok)
+	}
+	if v := h.Get(nil); v == nil || v.(string) != "empty" {
+		t.Fatalf("Expected empty key, got %v\n", v)
+	}
+	if v, ok := h.Remove(foo); !ok || string(v.([]byte)) != string(bar) {
+		t.Fatalf("Expected to remove bar, got %v, %v\n", v, ok)
+	}
+	if h.Contains(foo) || h.Count() != 2 {
+		t.Fatalf("foo should have been removed\n")
+	}
+	if len(h.AllKeys()) != 2 || len(h.All()) != 2 {
+		t.Fatalf("Expected 2 keys and values\n")
+	}
+}
+
+func TestCuckooMapStash(t *testing.T) {
+	h, _ := NewCuckooMapWithBkts(64)
+	// All keys share one hash and so both buckets: 8 fit in the
+	// buckets, the next ones go to the stash.
+	h.Hash = func([]byte) uint32 { return 42 }
+	keys := parallelKeys(2*_CWAY + _CSTASH)
+	for i, k := range keys {
+		h.Set(k, i)
+	}
+	if len(h.stash) != _CSTASH || h.rehashes != 0 {
+		t.Fatalf("Expected a full stash and no rehash, stash %d rehashes %d\n",
+			len(h.stash), h.rehashes)
+	}
+	if s := h.Stats(); s.MaxProbe != 2 {
+		t.Fatalf("Stashed entries should have probe distance 2: %+v\n", s)
+	}
+	// One more cannot be placed with any seeds, the insertion fails
+	// and the map switches to a keyed hash without growing.
+	keys = append(keys, []byte("one.more"))
+	h.Set(keys[len(keys)-1], len(keys)-1)
+	if h.rehashes != 1 || h.keyed == nil || len(h.stash) > _CSTASH || len(h.bkts) != 64 {
+		t.Fatalf("Expected a rehash to a keyed hash, stash %d rehashes %d buckets %d\n",
+			len(h.stash), h.rehashes, len(h.bkts))
+	}
+	for i, k := range keys {
+		if v, ok := h.Lookup(k); !ok || v.(int) != i {
+			t.Fatalf("Lookup('%s') = %v, %v vs %d\n", k, v, ok, i)
+		}
+	}
+	// Removing from the stash keeps the rest reachable
+	for _, k := range keys[:5] {
+		h.Remove(k)
+	}
+	for i, k := range keys {
+		if _, ok := h.Lookup(k); ok != (i >= 5) {
+			t.Fatalf("Lookup('%s') found %v\n", k, ok)
+		}
+	}
+}
+
+func TestCuckooMapRehash(t *testing.T) {
+	h, _ := NewCuckooMapWithBkts(64)
+	// Only 8 distinct hashes: their 16 candidate buckets cannot hold
+	// everything, the map has to rehash to a keyed hash.
+	h.Hash = func(k []byte) uint32 { return uint32(k[len(k)-1]) & 7 }
+	keys := parallelKeys(8 * (2*_CWAY - 1))
+	for i, k := range keys {
+		h.Set(k, i)
+	}
+	if h.rehashes == 0 || h.keyed == nil {
+		t.Fatalf("Expected insertion failures to rehash\n")
+	}
+	for i, k := range keys {
+		if v, ok := h.Lookup(k); !ok || v.(int) != i {
+			t.Fatalf("Lookup('%s') = %v, %v vs %d\n", k, v, ok, i)
+		}
+	}
+	if h.Count() != uint32(len(keys)) || len(h.AllKeys()) != len(keys) {
+		t.Fatalf("Wrong number of entries: %d vs %d\n", h.Count(), len(keys))
+	}
+}
+
+func TestCuckooMapCollisionFlood(t *testing.T) {
+	h, _ := NewCuckooMapWithBkts(_CMINBKTS)
+	// Keys crafted to share one hash must not blow up the buckets:
+	// 0.9 load needs 4096 buckets for these, growing by 2 at most
+	// doubles that.
+	h.Hash = func([]byte) uint32 { return 42 }
+	keys := parallelKeys(10000)
+	for i, k := range keys {
+		h.Set(k, i)
+		if len(h.bkts) > 8192 || len(h.stash) > _CSTASH {
+			t.Fatalf("%d keys take %d buckets and a stash of %d\n", i+1, len(h.bkts), len(h.stash))
+		}
+	}
+	for i, k := range keys {
+		if v, ok := h.Lookup(k); !ok || v.(int) != i {
+			t.Fatalf("Lookup('%s') = %v, %v vs %d\n", k, v, ok, i)
+		}
+	}
+	if s := h.Stats(); s.MaxProbe > 2 || s.NumElements != uint32(len(keys)) {
+		t.Fatalf("Unexpected stats: %+v\n", s)
+	}
+}
+
+func TestCuckooMapRandom(t *testing.T) {
+	h := NewCuckooMap()
+	ref := make(map[string]int)
+	r := mrand.New(mrand.NewSource(1))
+	for i := 0; i < 200000; i++ {
+		k := fmt.Sprintf("foo.%d", r.Intn(4000))
+		switch r.Intn(3) {
+		case 0:
+			h.Set([]byte(k), i)
+			ref[k] = i
+		case 1:
+			_, ok1 := h.Remove([]byte(k))
+			_, ok2 := ref[k]
+			delete(ref, k)
+			if ok1 != ok2 {
+				t.Fatalf("Remove('%s') = %v vs %v\n", k, ok1, ok2)
+			}
+		case 2:
+			v, ok1 := h.Lookup([]byte(k))
+			rv, ok2 := ref[k]
+			if ok1 != ok2 || ok1 && v.(int) != rv {
+				t.Fatalf("Lookup('%s') = %v, %v vs %v, %v\n", k, v, ok1, rv, ok2)
+			}
+		}
+		if h.Count() != uint32(len(ref)) {
+			t.Fatalf("Wrong number of entries: %d vs %d\n", h.Count(), len(ref))
+		}
+	}
+	for k := range ref {
+		h.Remove([]byte(k))
+	}
+	if h.Count() != 0 || len(h.bkts) != _CMINBKTS {
+		t.Fatalf("Expected an empty map of %d buckets, got %d of %d\n", _CMINBKTS, h.Count(), len(h.bkts))
+	}
+}
+
+func TestCuckooMapHighLoad(t *testing.T) {
+	h, _ := NewCuckooMapWithBkts(1 << 14)
+	n := (1 << 16) * _CLOAD / 10
+	keys := parallelKeys(n)
+	for i, k := range keys {
+		h.Set(k, i)
+	}
+	if s := h.Stats(); s.NumBuckets != 1<<14 || s.NumElements != uint32(n) {
+		t.Fatalf("Should hold load 0.9 without growing: %+v\n", s)
+	}
+	for i, k := range keys {
+		if v := h.Get(k); v == nil || v.(int) != i {
+			t.Fatalf("Did not match properly, %v vs %d\n", v, i)
+		}
+	}
+}
+
+func Benchmark_CuckooMap____MissLoad9(b *testing.B) {
+	h, _ := NewCuckooMapWithBkts(1 << 14)
+	benchmarkMissLoad9(b, h)
+}
+
+func Benchmark_CuckooMap_GetSmallKey_1024(b *testing.B) {
+	size := 1024
+	m := NewCuckooMap()
+	keys := parallelKeys(size)
+	for _, k := range keys {
+		m.Set(k, bar)
+	}
+	b.ResetTimer()
+
+	Grp := b.N / size
+	for g := 0; g < Grp; g++ {
+		for i := 0; i < size; i++ {
+			_ = m.Get(keys[i])
+		}
+	}
+}
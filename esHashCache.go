@@ -13,58 +13,51 @@ func init() {
 	rand.Seed(time.Now().UnixNano())
 }
 
+// HashCache is a HashMap for caching. Bounded by WithMaxEntries,
+// Set evicts elements according to the policy set by WithPolicy.
 type HashCache struct {
 	HashMap
 }
 
 // New creates a new HashMap of default size and using the default
 // Hashing algorithm.
-func NewHashCache() *HashCache {
-	h, _ := NewHashCacheWithBkts(make([]*Entry, _BSZ))
+func NewHashCache(opts ...Option) *HashCache {
+	h, _ := NewHashCacheWithBkts(make([]*Entry, _BSZ), opts...)
 	return h
 }
 
 // NewWithBkts creates a new HashMap using the bkts slice argument.
 // len(bkts) must be a power of 2.
-func NewHashCacheWithBkts(bkts []*Entry) (*HashCache, error) {
+func NewHashCacheWithBkts(bkts []*Entry, opts ...Option) (*HashCache, error) {
 	l := len(bkts)
 	if l == 0 || (l&(l-1) != 0) {
 		return nil, errors.New("Size of buckets must be power of 2")
 	}
 	h := HashCache{}
-	h.msk = uint32(l - 1)
-	h.bkts = bkts
-	h.Hash = DefaultHash
-	h.rsz = true
+	h.init(bkts, opts)
+	if h.ev == nil {
+		// Unbounded, keep the entries in a set all the same for
+		// RemoveRandom to pick from uniformly.
+		h.ev = &randomEvictor{newEntrySet()}
+	}
 	return &h, nil
 }
 
 // RemoveRandom can be used for a random policy eviction.
-// This is stochastic but very fast and does not impede
-// performance like LRU, LFU or even ARC based implementations.
+// This is stochastic but very fast, see the Throughput benchmarks
+// for what the other policies cost per operation.
+// Unbounded or with PolicyRandom or a sampled policy the element is
+// chosen uniformly, otherwise elements in long chains or behind
+// empty buckets are less likely to go, see randomEntry. The OnEvict
+// callback sees the element with EvictExplicit.
 func (h *HashCache) RemoveRandom() {
 	if h.used == 0 {
 		return
 	}
-	index := (rand.Int()) & int(h.msk)
-	// Walk forward til we find an entry
-	for i := index; i < len(h.bkts); i++ {
-		e := &h.bkts[i]
-		if *e != nil {
-			*e = (*e).next
-			h.used -= 1
-			return
-		}
-	}
-	// If we are here we hit end and did not remove anything,
-	// use the index and walk backwards.
-	for i := index; i >= 0; i-- {
-		e := &h.bkts[i]
-		if *e != nil {
-			*e = (*e).next
-			h.used -= 1
-			return
-		}
+	h.finishRehash()
+	e := h.randomEntry()
+	if e == nil {
+		panic("Should not reach here..")
 	}
-	panic("Should not reach here..")
+	h.evict(e, EvictExplicit)
 }
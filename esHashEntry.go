@@ -3,18 +3,24 @@ package esMap
 
 import (
 	"fmt"
-	"unsafe"
+	"strings"
 )
 
 // Entry represents what the map is actually storing.
 // Uses simple linked list resolution for collisions.
 type Entry struct {
 	blk  *EntryBlock
-	hk   uint32
-	id   uint32
+	hk   uint64
 	key  []byte
 	data interface{}
 	next *Entry
+	// Eviction policy state: the position in the dense array of
+	// entries, the age or use count, and the links of the list of
+	// entries in eviction order.
+	pos   uint32
+	stamp uint32
+	lprev *Entry
+	lnext *Entry
 }
 
 const (
@@ -24,6 +30,7 @@ const (
 type EntryBlock struct {
 	entries []Entry
 	free    *Entry
+	freeCnt int
 	nextBlk *EntryBlock
 }
 
@@ -32,9 +39,9 @@ func newEntryBlock(blockSize int) *EntryBlock {
 	eb.entries = make([]Entry, blockSize)
 	for i := 0; i < blockSize; i++ {
 		eb.entries[i].blk = eb
-		eb.entries[i].id = uint32(i)
 	}
 	eb.free = &eb.entries[0]
+	eb.freeCnt = blockSize
 	for i := 1; i < blockSize; i++ {
 		eb.entries[i-1].next = &eb.entries[i]
 	}
@@ -46,6 +53,10 @@ type EntryArea struct {
 	totalCnt  int
 	freeCnt   int
 	freeBlk   *EntryBlock
+	blks      []*EntryBlock // every block, free or not
+	minCnt    int           // entries Tidy always retains
+	tidyRatio float64       // free ratio that triggers Tidy from Put, 0 is off
+	tidyMark  int           // freeCnt at which Put runs Tidy
 }
 
 func newEntryArea(blockSize int) *EntryArea {
@@ -57,6 +68,7 @@ func newEntryArea(blockSize int) *EntryArea {
 	ea.totalCnt = blockSize
 	ea.freeCnt = blockSize
 	ea.freeBlk = newEntryBlock(ea.blockSize)
+	ea.blks = append(ea.blks, ea.freeBlk)
 	return ea
 }
 
@@ -65,47 +77,173 @@ func (ea *EntryArea) Get() *Entry {
 		blk := newEntryBlock(ea.blockSize)
 		blk.nextBlk = ea.freeBlk
 		ea.freeBlk = blk
+		ea.blks = append(ea.blks, blk)
 		ea.totalCnt += len(blk.entries)
 		ea.freeCnt += len(blk.entries)
+		ea.setTidyMark()
 	}
 	en := ea.freeBlk.free
 	ea.freeBlk.free = en.next
+	ea.freeBlk.freeCnt--
 	ea.freeCnt--
 	if ea.freeBlk.free == nil {
-		ea.freeBlk = ea.freeBlk.nextBlk
+		// Unlink the full block completely, a stale nextBlk
+		// would keep blocks released by Tidy reachable.
+		blk := ea.freeBlk
+		ea.freeBlk = blk.nextBlk
+		blk.nextBlk = nil
 	}
 	return en
 }
 
 func (ea *EntryArea) Put(en *Entry) {
+	// Drop references so a free Entry does not keep its
+	// key and data alive.
+	en.hk, en.key, en.data = 0, nil, nil
 	if en.blk.free == nil {
 		en.blk.nextBlk = ea.freeBlk
 		ea.freeBlk = en.blk
 	}
 	en.next = en.blk.free
 	en.blk.free = en
+	en.blk.freeCnt++
 	ea.freeCnt++
+	if ea.tidyRatio > 0 && ea.freeCnt >= ea.tidyMark {
+		ea.Tidy()
+	}
+}
+
+// EntryAreaStats are reported on EntryAreas
+type EntryAreaStats struct {
+	BlockSize  int
+	NumBlocks  int
+	TotalCnt   int
+	FreeCnt    int
+	InUseCnt   int
+	FreeBlocks int // blocks with no entry in use
+	FullBlocks int // blocks with every entry in use
+	// Occupancy is a histogram of the blocks by the share of their
+	// entries in use: 0%, up to 25%, 50%, 75%, below 100% and 100%.
+	Occupancy [6]int
+	// Fragmentation is the share of the free entries that sit in
+	// blocks still in use, which Tidy cannot release.
+	Fragmentation float64
 }
 
-func (ea *EntryArea) Stats() []string {
-	S := make([]string, 0, ea.freeCnt)
-	S = append(S, "\n")
-	S = append(S, fmt.Sprintf("totalCnt            : %d\n", ea.totalCnt))
-	S = append(S, fmt.Sprintf("freeCnt             : %d\n", ea.freeCnt))
+// Stats will collect statistics about the EntryArea
+func (ea *EntryArea) Stats() *EntryAreaStats {
+	s := &EntryAreaStats{
+		BlockSize: ea.blockSize,
+		NumBlocks: len(ea.blks),
+		TotalCnt:  ea.totalCnt,
+		FreeCnt:   ea.freeCnt,
+		InUseCnt:  ea.totalCnt - ea.freeCnt,
+	}
+	stuck := 0
+	for _, eb := range ea.blks {
+		n := len(eb.entries)
+		used := n - eb.freeCnt
+		switch used {
+		case 0:
+			s.FreeBlocks++
+			s.Occupancy[0]++
+		case n:
+			s.FullBlocks++
+			s.Occupancy[5]++
+		default:
+			stuck += eb.freeCnt
+			s.Occupancy[1+(used*4-1)/n]++
+		}
+	}
+	if ea.freeCnt > 0 {
+		s.Fragmentation = float64(stuck) / float64(ea.freeCnt)
+	}
+	return s
+}
+
+// String formats the statistics for humans.
+func (s *EntryAreaStats) String() string {
+	return fmt.Sprintf("blocks: %d x %d, entries: %d (%d in use, %d free), "+
+		"blocks free/full: %d/%d, occupancy [0%%,25%%,50%%,75%%,<100%%,100%%]: %v, "+
+		"fragmentation: %.2f",
+		s.NumBlocks, s.BlockSize, s.TotalCnt, s.InUseCnt, s.FreeCnt,
+		s.FreeBlocks, s.FullBlocks, s.Occupancy, s.Fragmentation)
+}
+
+// Dump returns a debug dump of the blocks with free entries and
+// of their free lists.
+func (ea *EntryArea) Dump() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "totalCnt            : %d\n", ea.totalCnt)
+	fmt.Fprintf(&b, "freeCnt             : %d\n", ea.freeCnt)
 	ebi := 0
 	for eb := ea.freeBlk; eb != nil; eb = eb.nextBlk {
 		ebi++
 		eni := 0
-		S = append(S, fmt.Sprintf("EntryBlock[%3d]     : %d\n", ebi, unsafe.Pointer(eb)))
+		fmt.Fprintf(&b, "EntryBlock[%3d]     : %p free %d/%d\n",
+			ebi, eb, eb.freeCnt, len(eb.entries))
 		for en := eb.free; en != nil; en = en.next {
 			eni++
-			S = append(S, fmt.Sprintf("     Entry[%3d][%3d]: %d -> %d\n",
-				ebi, eni, unsafe.Pointer(en), en))
-
+			fmt.Fprintf(&b, "     Entry[%3d][%3d]: %p -> %p\n",
+				ebi, eni, en, en.next)
 		}
 	}
-	return S
+	return b.String()
 }
-func (ea *EntryArea) Tidy() {
 
+// SetTidy configures Tidy. Tidy never shrinks the area below
+// minRetain entries. When freeRatio is above 0, Put runs Tidy
+// automatically once the share of free entries reaches freeRatio.
+func (ea *EntryArea) SetTidy(minRetain int, freeRatio float64) {
+	if minRetain < 0 {
+		minRetain = 0
+	}
+	ea.minCnt = minRetain
+	ea.tidyRatio = freeRatio
+	ea.setTidyMark()
+}
+
+// setTidyMark computes the freeCnt at which Put runs Tidy again. It
+// waits for at least another block worth of free entries, so a
+// fragmented area with nothing to release is not walked on every Put.
+func (ea *EntryArea) setTidyMark() {
+	ea.tidyMark = int(ea.tidyRatio * float64(ea.totalCnt))
+	if next := ea.freeCnt + ea.blockSize; ea.tidyMark < next {
+		ea.tidyMark = next
+	}
+}
+
+// Tidy releases the blocks whose entries are all free, so that the
+// garbage collector can reclaim them, while keeping at least the
+// minimum retained capacity set by SetTidy.
+func (ea *EntryArea) Tidy() {
+	dropped := false
+	prev := &ea.freeBlk
+	for eb := ea.freeBlk; eb != nil; eb = *prev {
+		n := len(eb.entries)
+		if eb.freeCnt == n && ea.totalCnt-n >= ea.minCnt {
+			*prev = eb.nextBlk
+			eb.nextBlk = nil
+			eb.entries = nil
+			ea.totalCnt -= n
+			ea.freeCnt -= n
+			dropped = true
+			continue
+		}
+		prev = &eb.nextBlk
+	}
+	if dropped {
+		// Released blocks have no entries left, compact them away.
+		blks := ea.blks[:0]
+		for _, eb := range ea.blks {
+			if eb.entries != nil {
+				blks = append(blks, eb)
+			}
+		}
+		for i := len(blks); i < len(ea.blks); i++ {
+			ea.blks[i] = nil
+		}
+		ea.blks = blks
+	}
+	ea.setTidyMark()
 }
@@ -3,23 +3,13 @@ package esMap
 
 import (
 	"fmt"
+	"runtime"
 	"runtime/debug"
 	"testing"
 	"time"
+	"unsafe"
 )
 
-func CompareSlice(s1, s2 []string) bool {
-	if len(s1) != len(s2) {
-		return false
-	}
-	for i, s := range s1 {
-		if s != s2[i] {
-			return false
-		}
-	}
-	return true
-}
-
 func TestEntryArea(t *testing.T) {
 	const (
 		isPrintf = false
@@ -40,35 +30,77 @@ func TestEntryArea(t *testing.T) {
 	for i := 0; i < totalCnt; i++ {
 		ens[i] = ea.Get()
 	}
-	printf("ens: %d\n", ens)
+	printf("ens: %p\n", ens)
 	M1 := ea.Stats()
 	printf("M1.Stats:%v\n", M1)
+	if M1.NumBlocks != 4 || M1.FullBlocks != 4 || M1.InUseCnt != totalCnt || M1.Occupancy[5] != 4 {
+		t.Errorf("M1 stats wrong: %v", M1)
+	}
 	for i := len(ens) - 1; i >= 0; i-- {
 		ea.Put(ens[i])
 		ens[i] = nil
 	}
-	printf("ens: %d\n", ens)
+	printf("ens: %p\n", ens)
 	M2 := ea.Stats()
-	printf("M2.Stats:%v\n", M2)
+	D2 := ea.Dump()
+	printf("M2.Stats:%v\n%s", M2, D2)
+	if M2.FreeBlocks != 4 || M2.FreeCnt != totalCnt || M2.Fragmentation != 0 {
+		t.Errorf("M2 stats wrong: %v", M2)
+	}
 
 	for i := 0; i < totalCnt; i++ {
 		ens[i] = ea.Get()
 	}
-	printf("ens : %d\n", ens)
+	printf("ens : %p\n", ens)
 	M3 := ea.Stats()
 	printf("M3.Stats:%v\n", M3)
 	for i := len(ens) - 1; i >= 0; i-- {
 		ea.Put(ens[i])
 		ens[i] = nil
 	}
-	printf("ens: %d\n", ens)
+	printf("ens: %p\n", ens)
 	M4 := ea.Stats()
-	printf("M4.Stats:%v\n", M4)
-	if !CompareSlice(M2, M4) {
+	D4 := ea.Dump()
+	printf("M4.Stats:%v\n%s", M4, D4)
+	if *M2 != *M4 || D2 != D4 {
 		t.Error("stats not eqit")
 	}
 }
 
+func TestEntryAreaStats(t *testing.T) {
+	blockSize := 8
+	ea := newEntryArea(blockSize)
+	ens := make([]*Entry, 4*blockSize)
+	for i := range ens {
+		ens[i] = ea.Get()
+	}
+	// Leave the blocks with 8, 6, 2 and 0 entries in use.
+	for b, keep := range []int{8, 6, 2, 0} {
+		for i := keep; i < blockSize; i++ {
+			ea.Put(ens[b*blockSize+i])
+		}
+	}
+	s := ea.Stats()
+	exp := EntryAreaStats{
+		BlockSize:     blockSize,
+		NumBlocks:     4,
+		TotalCnt:      4 * blockSize,
+		FreeCnt:       16,
+		InUseCnt:      16,
+		FreeBlocks:    1,
+		FullBlocks:    1,
+		Occupancy:     [6]int{1, 1, 0, 1, 0, 1},
+		Fragmentation: 8.0 / 16.0,
+	}
+	if *s != exp {
+		t.Fatalf("Stats incorrect:\n%v\nvs\n%v\n", s, &exp)
+	}
+	ea.Tidy()
+	if s = ea.Stats(); s.NumBlocks != 3 || s.FreeBlocks != 0 || s.Fragmentation != 1 {
+		t.Fatalf("Stats after Tidy incorrect: %v\n", s)
+	}
+}
+
 func TestEntryAreaGetPut(t *testing.T) {
 	N := 10000 * 10000
 	ea := newEntryArea(0)
@@ -140,3 +172,84 @@ func TestEntryAreaBath(t *testing.T) {
 		t.Logf("\t%s", s)
 	}
 }
+
+func heapAlloc() uint64 {
+	var ms runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
+
+func TestEntryAreaTidy(t *testing.T) {
+	// Large blocks so the released memory stands out in HeapAlloc.
+	blockSize := 1 << 14
+	blockMem := uint64(blockSize) * uint64(unsafe.Sizeof(Entry{}))
+	ea := newEntryArea(blockSize)
+	ea.SetTidy(2*blockSize, 0)
+	ens := make([]*Entry, 8*blockSize)
+	for i := range ens {
+		ens[i] = ea.Get()
+	}
+	// Free all but the first entry.
+	for i := 1; i < len(ens); i++ {
+		ea.Put(ens[i])
+		ens[i] = nil
+	}
+	before := heapAlloc()
+	ea.Tidy()
+	after := heapAlloc()
+	// One block is in use and one more is retained.
+	if ea.totalCnt != 2*blockSize {
+		t.Fatalf("Tidy should retain 2 blocks, totalCnt %d vs %d\n", ea.totalCnt, 2*blockSize)
+	}
+	if ea.freeCnt != ea.totalCnt-1 {
+		t.Fatalf("freeCnt is wrong: %d vs %d\n", ea.freeCnt, ea.totalCnt-1)
+	}
+	if after > before || before-after < 5*blockMem {
+		t.Fatalf("Expected 6 blocks of %d bytes to be collected, heap %d -> %d\n",
+			blockMem, before, after)
+	}
+	// The area must keep working after Tidy.
+	for i := 1; i < len(ens); i++ {
+		ens[i] = ea.Get()
+	}
+	if ea.totalCnt-ea.freeCnt != len(ens) {
+		t.Fatalf("Wrong number of entries in use: %d vs %d\n", ea.totalCnt-ea.freeCnt, len(ens))
+	}
+	runtime.KeepAlive(ens)
+}
+
+func TestEntryAreaAutoTidy(t *testing.T) {
+	blockSize := 4
+	ea := newEntryArea(blockSize)
+	ea.SetTidy(blockSize, 0.75)
+	ens := make([]*Entry, 16*blockSize)
+	for i := range ens {
+		ens[i] = ea.Get()
+	}
+	for i := range ens {
+		ea.Put(ens[i])
+		ens[i] = nil
+	}
+	if ea.freeCnt > ea.totalCnt || float64(ea.freeCnt) >= 0.75*float64(ea.totalCnt) && ea.totalCnt > blockSize {
+		t.Fatalf("Put did not tidy, free %d of %d\n", ea.freeCnt, ea.totalCnt)
+	}
+	if ea.totalCnt != blockSize {
+		t.Fatalf("Expected only the retained block, totalCnt %d vs %d\n", ea.totalCnt, blockSize)
+	}
+}
+
+func TestHashMapEntryTidy(t *testing.T) {
+	h := NewHashMap(WithEntryTidy(DefBlockSize, 0.5))
+	keys := make([][]byte, 100*DefBlockSize)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("foo.%d", i))
+		h.Set(keys[i], i)
+	}
+	for i := range keys {
+		h.Remove(keys[i])
+	}
+	if h.area.totalCnt > 2*DefBlockSize {
+		t.Fatalf("Free entries were not released, totalCnt %d\n", h.area.totalCnt)
+	}
+}
@@ -0,0 +1,379 @@
+// Eviction policies of bounded maps.
+package esMap
+
+import (
+	"fmt"
+	"math/rand"
+	randv2 "math/rand/v2"
+)
+
+// Policy selects which entry a bounded map evicts to make room.
+type Policy int
+
+const (
+	// PolicyRandom evicts an entry chosen uniformly at random.
+	PolicyRandom Policy = iota
+	// PolicySampledLRU evicts the least recently used of a few
+	// entries sampled at random, like the approximated LRU of Redis.
+	PolicySampledLRU
+	// PolicySampledLFU evicts the least frequently used of a few
+	// entries sampled at random, like the LFU of Redis. Use counts
+	// halve every max entries accesses, so entries once popular
+	// fade out.
+	PolicySampledLFU
+	// PolicyLRU evicts the least recently used entry, keeping the
+	// entries in a list through Entry in the order of their use.
+	PolicyLRU
+	// PolicyLFU evicts the least frequently used entry, the least
+	// recently used of those if there are several. Use counts never
+	// fade, so it suits stable popularity and does badly when the
+	// working set moves.
+	PolicyLFU
+	// PolicyTinyLFU is W-TinyLFU: new entries go to a small LRU
+	// window, and leave it for the main segmented LRU only if they
+	// are more frequent than the entry they would push out, as
+	// estimated by a Count-Min sketch with aging.
+	PolicyTinyLFU
+	// PolicyARC is the Adaptive Replacement Cache. It balances a
+	// list of entries used once against one of entries used again,
+	// steered by ghost lists of the keys recently evicted from them.
+	PolicyARC
+	// Policy2Q admits new entries to a FIFO queue, and only keys
+	// used again after leaving it, as remembered by a ghost list,
+	// to the main LRU.
+	Policy2Q
+	// PolicyS3FIFO uses a small FIFO queue for new entries and a
+	// main FIFO queue for entries used while in the small one or
+	// evicted recently, with lazy promotion by use counts.
+	PolicyS3FIFO
+	// PolicySIEVE keeps a single FIFO queue with a visited bit per
+	// entry and a hand sweeping it for unvisited entries.
+	PolicySIEVE
+)
+
+// String returns the name of the policy.
+func (p Policy) String() string {
+	switch p {
+	case PolicyRandom:
+		return "random"
+	case PolicySampledLRU:
+		return "sampled-lru"
+	case PolicySampledLFU:
+		return "sampled-lfu"
+	case PolicyLRU:
+		return "lru"
+	case PolicyLFU:
+		return "lfu"
+	case PolicyTinyLFU:
+		return "tinylfu"
+	case PolicyARC:
+		return "arc"
+	case Policy2Q:
+		return "2q"
+	case PolicyS3FIFO:
+		return "s3-fifo"
+	case PolicySIEVE:
+		return "sieve"
+	}
+	return fmt.Sprintf("Policy(%d)", int(p))
+}
+
+// _SAMPLES is the default number of entries sampled per eviction.
+const _SAMPLES = 5
+
+// EvictReason tells the OnEvict callback why an element went.
+type EvictReason int
+
+const (
+	// EvictCapacity makes room for a new element in a full map.
+	EvictCapacity EvictReason = iota
+	// EvictExplicit is an eviction asked for, as by RemoveRandom.
+	EvictExplicit
+	// EvictClear is the removal of every element by Clear.
+	EvictClear
+)
+
+// String returns the name of the reason.
+func (r EvictReason) String() string {
+	switch r {
+	case EvictCapacity:
+		return "capacity"
+	case EvictExplicit:
+		return "explicit"
+	case EvictClear:
+		return "clear"
+	}
+	return fmt.Sprintf("EvictReason(%d)", int(r))
+}
+
+// evictor is the state of an eviction policy. The HashMap tells it
+// about every entry added, accessed by Get, Lookup, Set or the read
+// modify write operations, and removed. When full, it asks for a
+// victim to make room for the key with hash hk.
+type evictor interface {
+	added(e *Entry)
+	accessed(e *Entry)
+	removed(e *Entry)
+	victim(hk uint64) *Entry
+}
+
+// evictor returns the state of policy p for h.
+func (p Policy) evictor(h *HashMap) evictor {
+	switch p {
+	case PolicyRandom:
+		return &randomEvictor{newEntrySet()}
+	case PolicySampledLRU, PolicySampledLFU:
+		ev := &sampledEvictor{entrySet: newEntrySet(), lfu: p == PolicySampledLFU}
+		ev.samples = h.samples
+		if ev.samples <= 0 {
+			ev.samples = _SAMPLES
+		}
+		ev.period = h.max
+		return ev
+	case PolicyLRU:
+		return new(lruEvictor)
+	case PolicyLFU:
+		return newLFUEvictor()
+	case PolicyTinyLFU:
+		return newTinyLFU(int(h.max))
+	case PolicyARC:
+		return newARC(int(h.max))
+	case Policy2Q:
+		return new2Q(int(h.max))
+	case PolicyS3FIFO:
+		return newS3FIFO(int(h.max))
+	case PolicySIEVE:
+		return new(sieveEvictor)
+	}
+	panic(fmt.Sprintf("Unknown eviction policy %v", p))
+}
+
+// touch tells the eviction policy about an access of e.
+func (h *HashMap) touch(e *Entry) {
+	if h.ev != nil {
+		h.ev.accessed(e)
+	}
+}
+
+// evict removes e, telling the OnEvict callback about it.
+func (h *HashMap) evict(e *Entry, reason EvictReason) {
+	l := h.bucket(e.hk)
+	for *l != e {
+		l = &(*l).next
+	}
+	key, data := e.key, e.data
+	h.unlink(l)
+	if h.onEvict != nil {
+		h.onEvict(key, data, reason)
+	}
+}
+
+// randomEntry returns an entry chosen at random. The policies
+// keeping a dense array of entries pick uniformly. Otherwise it is
+// the first entry from a random bucket on, or the last before it
+// when there is none behind it, which is not uniform: chains share
+// the chance of their bucket and buckets after runs of empty ones
+// have a better chance.
+func (h *HashMap) randomEntry() *Entry {
+	if s, ok := h.ev.(interface{ random() *Entry }); ok {
+		return s.random()
+	}
+	index := rand.Int()
+	// While rehashing, the old buckets can hold entries as well.
+	for _, bkts := range h.chains() {
+		if len(bkts) == 0 {
+			continue
+		}
+		index %= len(bkts)
+		// Walk forward til we find an entry
+		for i := index; i < len(bkts); i++ {
+			if bkts[i] != nil {
+				return bkts[i]
+			}
+		}
+		// If we are here we hit end and did not find anything,
+		// use the index and walk backwards.
+		for i := index; i >= 0; i-- {
+			if bkts[i] != nil {
+				return bkts[i]
+			}
+		}
+	}
+	return nil
+}
+
+// entrySet is a dense array of the entries of a map, to pick one
+// uniformly at random in O(1). Entry.pos is the position of an entry.
+type entrySet struct {
+	ents []*Entry
+	rnd  *randv2.Rand
+}
+
+func newEntrySet() entrySet {
+	return entrySet{rnd: randv2.New(randv2.NewPCG(randv2.Uint64(), randv2.Uint64()))}
+}
+
+func (s *entrySet) added(e *Entry) {
+	e.pos = uint32(len(s.ents))
+	s.ents = append(s.ents, e)
+}
+
+// removed moves the last entry into the position of e.
+func (s *entrySet) removed(e *Entry) {
+	n := len(s.ents) - 1
+	last := s.ents[n]
+	s.ents[e.pos] = last
+	last.pos = e.pos
+	s.ents[n] = nil
+	s.ents = s.ents[:n]
+}
+
+// random returns an entry chosen uniformly at random, or nil.
+func (s *entrySet) random() *Entry {
+	if len(s.ents) == 0 {
+		return nil
+	}
+	return s.ents[s.rnd.IntN(len(s.ents))]
+}
+
+// randomEvictor is PolicyRandom.
+type randomEvictor struct {
+	entrySet
+}
+
+func (*randomEvictor) accessed(*Entry) {}
+
+func (r *randomEvictor) victim(uint64) *Entry {
+	return r.random()
+}
+
+// sampledEvictor is PolicySampledLRU and PolicySampledLFU. With LRU,
+// Entry.stamp is the clock of the last access. With LFU, its low 16
+// bits are the use count and its high 16 bits the epoch of the last
+// access, a new epoch starting every period accesses.
+type sampledEvictor struct {
+	entrySet
+	lfu     bool
+	samples int
+	clock   uint32
+	period  uint32
+	ticks   uint32
+}
+
+// _LFUMAX is the most uses counted.
+const _LFUMAX = 0xffff
+
+func (s *sampledEvictor) added(e *Entry) {
+	s.entrySet.added(e)
+	s.stamp(e, 0)
+}
+
+func (s *sampledEvictor) accessed(e *Entry) {
+	s.stamp(e, s.uses(e))
+}
+
+// stamp records an access of e, used n times before.
+func (s *sampledEvictor) stamp(e *Entry, n uint32) {
+	if !s.lfu {
+		e.stamp = s.clock
+		s.clock++
+		return
+	}
+	if n < _LFUMAX {
+		n++
+	}
+	e.stamp = s.clock<<16 | n
+	if s.ticks++; s.ticks >= s.period {
+		s.ticks = 0
+		s.clock++
+	}
+}
+
+// uses returns the use count of e, halved for every epoch since
+// its last access.
+func (s *sampledEvictor) uses(e *Entry) uint32 {
+	age := (s.clock - e.stamp>>16) & 0xffff
+	if age >= 16 {
+		return 0
+	}
+	return (e.stamp & _LFUMAX) >> age
+}
+
+// victim returns the least recently or frequently used of the
+// sampled entries.
+func (s *sampledEvictor) victim(uint64) *Entry {
+	var victim *Entry
+	var worst uint32
+	for i := 0; i < s.samples; i++ {
+		e := s.random()
+		if e == nil {
+			break
+		}
+		var score uint32
+		if s.lfu {
+			// Fewer uses are worse.
+			score = _LFUMAX - s.uses(e)
+		} else {
+			// Older is worse, the difference copes with the
+			// clock wrapping around.
+			score = s.clock - e.stamp
+		}
+		if victim == nil || score > worst {
+			victim, worst = e, score
+		}
+	}
+	return victim
+}
+
+// entryList is a doubly linked list of entries through Entry.lprev
+// and Entry.lnext, most recent at the front.
+type entryList struct {
+	front, back *Entry
+	n           int
+}
+
+// pushFront adds e at the front of the list.
+func (l *entryList) pushFront(e *Entry) {
+	e.lprev, e.lnext = nil, l.front
+	if l.front != nil {
+		l.front.lprev = e
+	} else {
+		l.back = e
+	}
+	l.front = e
+	l.n++
+}
+
+// remove takes e out of the list.
+func (l *entryList) remove(e *Entry) {
+	if e.lprev != nil {
+		e.lprev.lnext = e.lnext
+	} else {
+		l.front = e.lnext
+	}
+	if e.lnext != nil {
+		e.lnext.lprev = e.lprev
+	} else {
+		l.back = e.lprev
+	}
+	e.lprev, e.lnext = nil, nil
+	l.n--
+}
+
+// moveToFront moves e of the list to its front.
+func (l *entryList) moveToFront(e *Entry) {
+	if l.front != e {
+		l.remove(e)
+		l.pushFront(e)
+	}
+}
+
+// lruEvictor is PolicyLRU.
+type lruEvictor struct {
+	entryList
+}
+
+func (l *lruEvictor) added(e *Entry)       { l.pushFront(e) }
+func (l *lruEvictor) accessed(e *Entry)    { l.moveToFront(e) }
+func (l *lruEvictor) removed(e *Entry)     { l.remove(e) }
+func (l *lruEvictor) victim(uint64) *Entry { return l.back }
@@ -0,0 +1,492 @@
+package esMap
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"math/rand"
+	randv2 "math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// evicted records the calls of the OnEvict callback.
+type evicted struct {
+	keys    map[string]interface{}
+	reasons map[EvictReason]int
+}
+
+func newEvicted() *evicted {
+	return &evicted{make(map[string]interface{}), make(map[EvictReason]int)}
+}
+
+func (ev *evicted) onEvict(key []byte, data interface{}, reason EvictReason) {
+	ev.keys[string(key)] = data
+	ev.reasons[reason]++
+}
+
+func TestBoundedCache(t *testing.T) {
+	const max = 100
+	for _, opts := range [][]Option{nil, {WithIncrementalRehash(1)}, {WithKeyCopy()}} {
+		ev := newEvicted()
+		h := NewHashCache(append(opts, WithMaxEntries(max), WithOnEvict(ev.onEvict))...)
+		keys := parallelKeys(10 * max)
+		for i, k := range keys {
+			h.Set(k, i)
+			if h.Count() > max {
+				t.Fatalf("Cache holds %d entries, max %d\n", h.Count(), max)
+			}
+		}
+		if h.Count() != max || len(ev.keys) != len(keys)-max || ev.reasons[EvictCapacity] != len(ev.keys) {
+			t.Fatalf("%d entries left, %d evicted: %v\n", h.Count(), len(ev.keys), ev.reasons)
+		}
+		for i, k := range keys {
+			v, evicted := ev.keys[string(k)]
+			if evicted && (v.(int) != i || h.Contains(k)) {
+				t.Fatalf("Key '%s' evicted with %v, still present %v\n", k, v, h.Contains(k))
+			}
+			if !evicted && h.Get(k).(int) != i {
+				t.Fatalf("Key '%s' was neither kept nor evicted\n", k)
+			}
+		}
+		// Replacing a present key does not evict.
+		for _, k := range h.AllKeys() {
+			h.Set(k, nil)
+		}
+		if len(ev.keys) != len(keys)-max {
+			t.Fatalf("Replacing evicted %d keys\n", len(ev.keys)-(len(keys)-max))
+		}
+		h.RemoveRandom()
+		if h.Count() != max-1 || ev.reasons[EvictExplicit] != 1 {
+			t.Fatalf("RemoveRandom did not evict: %v\n", ev.reasons)
+		}
+		h.Clear()
+		if h.Count() != 0 || ev.reasons[EvictClear] != max-1 {
+			t.Fatalf("Clear did not evict: %v\n", ev.reasons)
+		}
+		if h.area.totalCnt > 2*max+DefBlockSize {
+			t.Fatalf("Bounded cache allocated %d entries\n", h.area.totalCnt)
+		}
+	}
+}
+
+func TestBoundedCacheRemove(t *testing.T) {
+	ev := newEvicted()
+	h := NewHashCache(WithMaxEntries(10), WithOnEvict(ev.onEvict))
+	for i := 0; i < 10; i++ {
+		h.Set([]byte(fmt.Sprintf("foo.%d", i)), i)
+	}
+	// Removed keys make room without evicting.
+	h.Remove([]byte("foo.0"))
+	h.GetOrSet(foo, nil)
+	if len(ev.keys) != 0 || h.Count() != 10 {
+		t.Fatalf("Evicted %d keys with room left\n", len(ev.keys))
+	}
+	h.Update(bar, func(interface{}, bool) (interface{}, bool) { return 1, true })
+	if len(ev.keys) != 1 || h.Count() != 10 {
+		t.Fatalf("Update into a full cache evicted %d keys\n", len(ev.keys))
+	}
+}
+
+func TestEvictReasonString(t *testing.T) {
+	if s := fmt.Sprint(EvictCapacity, EvictExplicit, EvictClear, EvictReason(9)); s != "capacity explicit clear EvictReason(9)" {
+		t.Fatalf("Wrong names: %s\n", s)
+	}
+}
+
+// chiSquare returns the chi-square statistic of counts against a
+// uniform distribution.
+func chiSquare(counts []int) float64 {
+	total := 0
+	for _, c := range counts {
+		total += c
+	}
+	exp := float64(total) / float64(len(counts))
+	x2 := 0.0
+	for _, c := range counts {
+		d := float64(c) - exp
+		x2 += d * d / exp
+	}
+	return x2
+}
+
+// seedPolicy makes the random choices of the policy of h repeatable.
+func seedPolicy(h *HashMap) {
+	var s *entrySet
+	switch ev := h.ev.(type) {
+	case *randomEvictor:
+		s = &ev.entrySet
+	case *sampledEvictor:
+		s = &ev.entrySet
+	}
+	s.rnd = randv2.New(randv2.NewPCG(1, 2))
+}
+
+func TestUniformEviction(t *testing.T) {
+	const (
+		size   = 20
+		trials = 20000
+		// Chi-square for 19 degrees of freedom at p = 0.001.
+		critical = 43.82
+	)
+	keys := parallelKeys(size + 1)
+	// Half of the keys share a chain, the others are spread out
+	// over a sparse table, which made bucket walking pick keys
+	// behind empty buckets or at the head of chains.
+	hash := func(k []byte) uint32 {
+		var i int
+		fmt.Sscanf(string(k), "foo.%d", &i)
+		if i < size/2 {
+			return 0
+		}
+		return uint32(i) * 97
+	}
+	counts := make([]int, size)
+	var victim int
+	h := NewHashCache(WithMaxEntries(size), WithCollisionThreshold(0),
+		WithOnEvict(func(key []byte, _ interface{}, _ EvictReason) {
+			fmt.Sscanf(string(key), "foo.%d", &victim)
+		}))
+	h.Hash = hash
+	h.Reserve(1 << 10)
+	seedPolicy(&h.HashMap)
+	for i := 0; i < trials; i++ {
+		for _, k := range keys[:size] {
+			h.Set(k, nil)
+		}
+		h.Set(keys[size], nil)
+		counts[victim]++
+		// The next trial sets the victim again, at the end of the
+		// dense array, so the positions vary between trials.
+		h.Remove(keys[size])
+	}
+	if x2 := chiSquare(counts); x2 > critical {
+		t.Fatalf("Evictions are not uniform, chi-square %.2f > %.2f: %v\n", x2, critical, counts)
+	}
+
+	// An unbounded cache picks uniformly as well.
+	u := NewHashCache(WithCollisionThreshold(0),
+		WithOnEvict(func(key []byte, _ interface{}, _ EvictReason) {
+			fmt.Sscanf(string(key), "foo.%d", &victim)
+		}))
+	u.Hash = hash
+	u.Reserve(1 << 10)
+	seedPolicy(&u.HashMap)
+	unbounded := make([]int, size)
+	for i := 0; i < trials; i++ {
+		for _, k := range keys[:size] {
+			u.Set(k, nil)
+		}
+		u.RemoveRandom()
+		unbounded[victim]++
+	}
+	if x2 := chiSquare(unbounded); x2 > critical {
+		t.Fatalf("Unbounded evictions are not uniform, chi-square %.2f > %.2f: %v\n", x2, critical, unbounded)
+	}
+
+	// Without an entry set, walking buckets is far from uniform.
+	m := NewHashMap(WithCollisionThreshold(0))
+	m.Hash = hash
+	m.Reserve(1 << 10)
+	for _, k := range keys[:size] {
+		m.Set(k, nil)
+	}
+	walk := make([]int, size)
+	for i := 0; i < trials; i++ {
+		fmt.Sscanf(string(m.randomEntry().key), "foo.%d", &victim)
+		walk[victim]++
+	}
+	if x2 := chiSquare(walk); x2 < critical {
+		t.Fatalf("Bucket walk should not be uniform, chi-square %.2f < %.2f\n", x2, critical)
+	}
+}
+
+func TestPolicyNeedsBound(t *testing.T) {
+	for _, opt := range []Option{WithPolicy(PolicyLRU), WithSamples(10)} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("Expected a panic without WithMaxEntries\n")
+				}
+			}()
+			NewHashCache(opt)
+		}()
+	}
+}
+
+func TestRemoveRandomUniform(t *testing.T) {
+	const (
+		size   = 100
+		trials = 100000
+		// Chi-square for 99 degrees of freedom at p = 0.001.
+		critical = 148.23
+	)
+	h := NewHashCache(WithMaxEntries(size))
+	seedPolicy(&h.HashMap)
+	keys := parallelKeys(size)
+	index := make(map[string]int)
+	for i, k := range keys {
+		h.Set(k, i)
+		index[string(k)] = i
+	}
+	counts := make([]int, size)
+	for i := 0; i < trials; i++ {
+		counts[index[string(h.randomEntry().key)]]++
+	}
+	if x2 := chiSquare(counts); x2 > critical {
+		t.Fatalf("RemoveRandom is not uniform, chi-square %.2f > %.2f\n", x2, critical)
+	}
+	for h.Count() > 0 {
+		h.RemoveRandom()
+	}
+	if s := h.ev.(*randomEvictor); len(s.ents) != 0 {
+		t.Fatalf("Dense array holds %d entries of an empty cache\n", len(s.ents))
+	}
+}
+
+// hotHitRatio runs a workload of hot keys, read through the cache
+// five times as often as cold keys are added, and returns the hit
+// ratio of the hot keys.
+func hotHitRatio(policy Policy) float64 {
+	const (
+		size = 100
+		hot  = 50
+	)
+	h := NewHashCache(WithMaxEntries(size), WithPolicy(policy))
+	seedPolicy(&h.HashMap)
+	keys := parallelKeys(hot)
+	hits, gets := 0, 0
+	for i := 0; i < 100*size; i++ {
+		h.Set([]byte(fmt.Sprintf("cold.%d", i)), nil)
+		for j := 0; j < 5; j++ {
+			k := keys[(5*i+j)%hot]
+			if _, ok := h.Lookup(k); ok {
+				hits++
+			} else {
+				h.Set(k, nil)
+			}
+			gets++
+		}
+	}
+	return float64(hits) / float64(gets)
+}
+
+func TestSampledEviction(t *testing.T) {
+	random := hotHitRatio(PolicyRandom)
+	for _, p := range []Policy{PolicySampledLRU, PolicySampledLFU} {
+		hits := hotHitRatio(p)
+		if hits < 0.9 || hits <= random {
+			t.Fatalf("Policy %v hit %.2f of the hot keys, random %.2f\n", p, hits, random)
+		}
+		t.Logf("Policy %v hit %.2f of the hot keys, random %.2f\n", p, hits, random)
+	}
+}
+
+func TestSampledEvictionClock(t *testing.T) {
+	h := NewHashCache(WithMaxEntries(10), WithPolicy(PolicySampledLRU), WithSamples(10))
+	s := h.ev.(*sampledEvictor)
+	// Let the clock wrap around while filling.
+	s.clock = 1<<32 - 5
+	for _, k := range parallelKeys(10) {
+		h.Set(k, nil)
+	}
+	// Every entry but the oldest has been accessed since.
+	for _, k := range parallelKeys(10)[1:] {
+		h.Get(k)
+	}
+	victims := 0
+	for i := 0; i < 100; i++ {
+		if string(s.victim(0).key) == "foo.0" {
+			victims++
+		}
+	}
+	// 10 samples miss foo.0 with probability 0.9^10.
+	if victims < 50 {
+		t.Fatalf("The oldest entry was the victim %d times of 100\n", victims)
+	}
+}
+
+func TestLRU(t *testing.T) {
+	ev := newEvicted()
+	h := NewHashCache(WithMaxEntries(4), WithPolicy(PolicyLRU), WithOnEvict(ev.onEvict))
+	keys := parallelKeys(8)
+	for _, k := range keys[:4] {
+		h.Set(k, nil)
+	}
+	// Use foo.0 and foo.2, foo.1 is the least recently used now.
+	h.Get(keys[0])
+	h.Set(keys[2], nil)
+	h.Contains(keys[1])
+	for i, exp := range []string{"foo.1", "foo.3", "foo.0", "foo.2"} {
+		h.Set(keys[4+i], nil)
+		if !ev.has(exp) || len(ev.keys) != i+1 {
+			t.Fatalf("Expected %s to be evicted, got %v\n", exp, ev.keys)
+		}
+	}
+	// Removing keeps the list intact.
+	h.Remove(keys[5])
+	h.Set(keys[0], nil)
+	h.Set(keys[1], nil)
+	if !ev.has("foo.4") || h.Count() != 4 {
+		t.Fatalf("Expected foo.4 to be evicted, got %v\n", ev.keys)
+	}
+	l := h.ev.(*lruEvictor)
+	n := 0
+	for e := l.front; e != nil; e = e.lnext {
+		if e.lnext != nil && e.lnext.lprev != e {
+			t.Fatalf("List is broken at %s\n", e.key)
+		}
+		n++
+	}
+	if n != 4 || l.n != 4 || string(l.front.key) != "foo.1" || string(l.back.key) != "foo.6" {
+		t.Fatalf("List holds %d entries from %s to %s\n", n, l.front.key, l.back.key)
+	}
+	h.Clear()
+	if l.front != nil || l.back != nil || l.n != 0 {
+		t.Fatalf("Clear left %d entries in the list\n", l.n)
+	}
+}
+
+func (ev *evicted) has(key string) bool {
+	_, ok := ev.keys[key]
+	return ok
+}
+
+// Synthetic, seeded traces for the hit ratio of the policies.
+const (
+	_TRACELEN  = 1 << 18
+	_TRACEKEYS = 1 << 14
+	_CACHESIZE = 1 << 10
+)
+
+// zipfTrace returns n requests for keys of a Zipf distribution
+// with exponent s over keys keys.
+func zipfTrace(n, keys int, s float64, seed int64) [][]byte {
+	z := rand.NewZipf(rand.New(rand.NewSource(seed)), s, 1, uint64(keys-1))
+	names := parallelKeys(keys)
+	trace := make([][]byte, n)
+	for i := range trace {
+		trace[i] = names[z.Uint64()]
+	}
+	return trace
+}
+
+// scanTrace returns a Zipf trace interrupted by scans: every period
+// requests, scan requests for keys never requested before.
+func scanTrace(n, keys int, period, scan int) [][]byte {
+	trace := zipfTrace(n, keys, 1.1, 1)
+	next := 0
+	for i := period; i+scan <= n; i += period + scan {
+		for j := i; j < i+scan; j++ {
+			trace[j] = []byte(fmt.Sprintf("scan.%d", next))
+			next++
+		}
+	}
+	return trace
+}
+
+// loadTrace reads a recorded trace from a gzipped file of testdata,
+// one key per line.
+func loadTrace(name string) [][]byte {
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	z, err := gzip.NewReader(f)
+	if err != nil {
+		panic(err)
+	}
+	var trace [][]byte
+	s := bufio.NewScanner(z)
+	for s.Scan() {
+		trace = append(trace, append([]byte{}, s.Bytes()...))
+	}
+	if err := s.Err(); err != nil {
+		panic(err)
+	}
+	return trace
+}
+
+// _RECORDEDSIZE is the cache size for the recorded trace, which
+// has about 2000 distinct keys.
+const _RECORDEDSIZE = 256
+
+var (
+	zipfTraceKeys = zipfTrace(_TRACELEN, _TRACEKEYS, 1.1, 1)
+	scanTraceKeys = scanTrace(_TRACELEN, _TRACEKEYS, 4*_CACHESIZE, 2*_CACHESIZE)
+	// nethttpTraceKeys is recorded: the identifiers of package
+	// net/http in source order, as a compiler looks them up, see
+	// testdata/idents.go.
+	nethttpTraceKeys = loadTrace("nethttp.trace.gz")
+)
+
+// hitRatio replays trace through a read-through cache of size
+// entries with policy and returns the share of hits.
+func hitRatio(policy Policy, size int, trace [][]byte) float64 {
+	h := NewHashCache(WithMaxEntries(size), WithPolicy(policy))
+	hits := 0
+	for _, k := range trace {
+		if _, ok := h.Lookup(k); ok {
+			hits++
+		} else {
+			h.Set(k, nil)
+		}
+	}
+	return float64(hits) / float64(len(trace))
+}
+
+func TestLRUHitRatio(t *testing.T) {
+	random := hitRatio(PolicyRandom, _CACHESIZE, zipfTraceKeys)
+	lru := hitRatio(PolicyLRU, _CACHESIZE, zipfTraceKeys)
+	if lru <= random {
+		t.Fatalf("LRU hit ratio %.3f should beat random %.3f on Zipf\n", lru, random)
+	}
+	random = hitRatio(PolicyRandom, _RECORDEDSIZE, nethttpTraceKeys)
+	lru = hitRatio(PolicyLRU, _RECORDEDSIZE, nethttpTraceKeys)
+	if lru <= random {
+		t.Fatalf("LRU hit ratio %.3f should beat random %.3f on net/http\n", lru, random)
+	}
+}
+
+func benchmarkHitRatio(b *testing.B, policy Policy, trace [][]byte) {
+	benchmarkHitRatioSize(b, policy, _CACHESIZE, trace)
+}
+
+func benchmarkHitRatioSize(b *testing.B, policy Policy, size int, trace [][]byte) {
+	var ratio float64
+	for i := 0; i < b.N; i++ {
+		ratio = hitRatio(policy, size, trace)
+	}
+	b.ReportMetric(100*ratio, "hit%")
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(trace)), "ns/req")
+}
+
+func Benchmark_HashCache_Zipf_Random(b *testing.B) {
+	benchmarkHitRatio(b, PolicyRandom, zipfTraceKeys)
+}
+func Benchmark_HashCache_Zipf_SampledLRU(b *testing.B) {
+	benchmarkHitRatio(b, PolicySampledLRU, zipfTraceKeys)
+}
+func Benchmark_HashCache_Zipf_LRU(b *testing.B) {
+	benchmarkHitRatio(b, PolicyLRU, zipfTraceKeys)
+}
+func Benchmark_HashCache_Scan_Random(b *testing.B) {
+	benchmarkHitRatio(b, PolicyRandom, scanTraceKeys)
+}
+func Benchmark_HashCache_Scan_SampledLRU(b *testing.B) {
+	benchmarkHitRatio(b, PolicySampledLRU, scanTraceKeys)
+}
+func Benchmark_HashCache_Scan_LRU(b *testing.B) {
+	benchmarkHitRatio(b, PolicyLRU, scanTraceKeys)
+}
+func Benchmark_HashCache_NetHTTP_Random(b *testing.B) {
+	benchmarkHitRatioSize(b, PolicyRandom, _RECORDEDSIZE, nethttpTraceKeys)
+}
+func Benchmark_HashCache_NetHTTP_SampledLRU(b *testing.B) {
+	benchmarkHitRatioSize(b, PolicySampledLRU, _RECORDEDSIZE, nethttpTraceKeys)
+}
+func Benchmark_HashCache_NetHTTP_LRU(b *testing.B) {
+	benchmarkHitRatioSize(b, PolicyLRU, _RECORDEDSIZE, nethttpTraceKeys)
+}
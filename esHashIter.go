@@ -0,0 +1,161 @@
+// Iteration over the elements of a HashMap.
+package esMap
+
+import (
+	"iter"
+	"math/bits"
+)
+
+// tombstone replaces the data of entries removed during an iteration.
+// Callers cannot store it, so it tells dead entries apart.
+type tombstone struct{}
+
+// Range calls fn for every element of the HashMap, in no particular
+// order, until fn returns false. It does not allocate.
+//
+// fn may Set and Remove keys, including the one it was called with.
+// Every element present for the whole iteration is visited exactly
+// once, an element removed before it is reached is not visited, and
+// an element set during the iteration may or may not be visited.
+// Resizing, incremental rehashing and the switch to a keyed hash
+// wait until the iteration ends, and so does the reuse of removed
+// entries. As with the other methods, concurrent use is not safe.
+func (h *HashMap) Range(fn func(key []byte, val interface{}) bool) {
+	h.iters++
+	defer h.endIter()
+	for _, bkts := range h.chains() {
+		for _, e := range bkts {
+			for ; e != nil; e = e.next {
+				if _, dead := e.data.(tombstone); dead {
+					continue
+				}
+				if !fn(e.key, e.data) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Keys returns an iterator over the keys of the HashMap, with the
+// semantics of Range.
+func (h *HashMap) Keys() iter.Seq[[]byte] {
+	return func(yield func([]byte) bool) {
+		h.Range(func(key []byte, _ interface{}) bool {
+			return yield(key)
+		})
+	}
+}
+
+// Values returns an iterator over the values of the HashMap, with
+// the semantics of Range.
+func (h *HashMap) Values() iter.Seq[interface{}] {
+	return func(yield func(interface{}) bool) {
+		h.Range(func(_ []byte, val interface{}) bool {
+			return yield(val)
+		})
+	}
+}
+
+// Entries returns an iterator over the keys and values of the
+// HashMap, with the semantics of Range.
+func (h *HashMap) Entries() iter.Seq2[[]byte, interface{}] {
+	return h.Range
+}
+
+// free returns a removed Entry to the EntryArea. While iterating,
+// an iteration may stand on the Entry, so it keeps its next and
+// is only marked dead until the last iteration ends.
+func (h *HashMap) free(e *Entry) {
+	if h.ev != nil {
+		h.ev.removed(e)
+	}
+	if h.keys != nil {
+		h.keys.release(e.key)
+	}
+	if h.iters > 0 {
+		e.data = tombstone{}
+		h.dead = append(h.dead, e)
+		return
+	}
+	h.area.Put(e)
+}
+
+// endIter ends an iteration, releasing the entries removed meanwhile
+// once no iteration is left.
+func (h *HashMap) endIter() {
+	if h.iters--; h.iters > 0 {
+		return
+	}
+	for i, e := range h.dead {
+		h.area.Put(e)
+		h.dead[i] = nil
+	}
+	h.dead = h.dead[:0]
+}
+
+// _SCANCOUNT is the default number of elements Scan returns per call.
+const _SCANCOUNT = 10
+
+// Scan walks the HashMap in small steps, like SCAN of Redis. A scan
+// starts with cursor 0 and every call returns about count elements
+// with the cursor to pass to the next call, until that cursor is 0.
+// Scan does not change the HashMap, which may be changed freely
+// between calls.
+//
+// Buckets are visited in reverse binary order of their index, so the
+// buckets visited before a resize map onto buckets visited before at
+// the new size. Every element present for the whole scan is returned
+// at least once, even if the HashMap grows or shrinks meanwhile, but
+// it may be returned more than once. The switch to a keyed hash on
+// collision floods reorders everything and voids the guarantee.
+func (h *HashMap) Scan(cursor uint64, count int) ([][]byte, []interface{}, uint64) {
+	if count <= 0 {
+		count = _SCANCOUNT
+	}
+	keys := make([][]byte, 0, count)
+	vals := make([]interface{}, 0, count)
+	// Bound the work on sparse maps.
+	for steps := 10 * count; steps > 0 && len(keys) < count; steps-- {
+		if h.obkts == nil {
+			keys, vals = appendChain(keys, vals, h.bkts[cursor&h.msk])
+			cursor = scanNext(cursor, h.msk)
+		} else {
+			// Visit the bucket of the smaller table and all the
+			// buckets of the larger table it expands to.
+			t0, m0, t1, m1 := h.obkts, h.omsk, h.bkts, h.msk
+			if len(t0) > len(t1) {
+				t0, m0, t1, m1 = t1, m1, t0, m0
+			}
+			keys, vals = appendChain(keys, vals, t0[cursor&m0])
+			for {
+				keys, vals = appendChain(keys, vals, t1[cursor&m1])
+				cursor = scanNext(cursor, m1)
+				if cursor&(m0^m1) == 0 {
+					break
+				}
+			}
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	return keys, vals, cursor
+}
+
+// scanNext increments the masked bits of cursor in reverse order.
+func scanNext(cursor, msk uint64) uint64 {
+	cursor |= ^msk
+	cursor = bits.Reverse64(cursor)
+	cursor++
+	return bits.Reverse64(cursor)
+}
+
+// appendChain appends the keys and values of the chain e.
+func appendChain(keys [][]byte, vals []interface{}, e *Entry) ([][]byte, []interface{}) {
+	for ; e != nil; e = e.next {
+		keys = append(keys, e.key)
+		vals = append(vals, e.data)
+	}
+	return keys, vals
+}
@@ -0,0 +1,249 @@
+package esMap
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRange(t *testing.T) {
+	h := NewHashMap()
+	keys := parallelKeys(1000)
+	for i, k := range keys {
+		h.Set(k, i)
+	}
+	seen := make(map[string]int)
+	h.Range(func(key []byte, val interface{}) bool {
+		if _, ok := seen[string(key)]; ok {
+			t.Fatalf("Key '%s' visited twice\n", key)
+		}
+		seen[string(key)] = val.(int)
+		return true
+	})
+	if len(seen) != len(keys) {
+		t.Fatalf("Range visited %d of %d keys\n", len(seen), len(keys))
+	}
+	for i, k := range keys {
+		if seen[string(k)] != i {
+			t.Fatalf("Key '%s' paired with %d vs %d\n", k, seen[string(k)], i)
+		}
+	}
+	n := 0
+	h.Range(func([]byte, interface{}) bool {
+		n++
+		return n < 10
+	})
+	if n != 10 {
+		t.Fatalf("Range did not stop early, %d calls\n", n)
+	}
+	if a := testing.AllocsPerRun(10, func() {
+		h.Range(func([]byte, interface{}) bool { return true })
+	}); a != 0 {
+		t.Fatalf("Range allocates %v times\n", a)
+	}
+}
+
+func TestIterators(t *testing.T) {
+	h := NewHashMap()
+	keys := parallelKeys(100)
+	for i, k := range keys {
+		h.Set(k, i)
+	}
+	sum, n := 0, 0
+	for k, v := range h.Entries() {
+		if h.Get(k).(int) != v.(int) {
+			t.Fatalf("Entries paired '%s' with %v\n", k, v)
+		}
+		n++
+	}
+	for v := range h.Values() {
+		sum += v.(int)
+	}
+	if n != len(keys) || sum != len(keys)*(len(keys)-1)/2 {
+		t.Fatalf("Iterators visited %d entries, values sum to %d\n", n, sum)
+	}
+	n = 0
+	for range h.Keys() {
+		if n++; n == 5 {
+			break
+		}
+	}
+	if n != 5 || h.iters != 0 {
+		t.Fatalf("Break did not end the iteration, %d keys, %d iterations\n", n, h.iters)
+	}
+}
+
+func TestRangeMutation(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithIncrementalRehash(1)}} {
+		h := NewHashMap(opts...)
+		keys := parallelKeys(1000)
+		for i, k := range keys {
+			h.Set(k, i)
+		}
+		// Leave an incremental rehash in progress.
+		for i := 1000; h.obkts == nil && len(opts) > 0; i++ {
+			h.Set([]byte(fmt.Sprintf("bar.%d", i)), i)
+		}
+		total, added := int(h.Count()), 0
+		seen := make(map[string]bool)
+		h.Range(func(key []byte, val interface{}) bool {
+			if seen[string(key)] {
+				t.Fatalf("Key '%s' visited twice\n", key)
+			}
+			if val == nil {
+				// Set during the iteration, it may or may not show up.
+				added++
+				return true
+			}
+			seen[string(key)] = true
+			// Remove the current key and one which may come later,
+			// and add plenty of keys to hit the growth threshold.
+			h.Remove(key)
+			if i := val.(int); i < len(keys) {
+				if _, ok := h.Remove(keys[len(keys)-1-i]); ok {
+					total--
+				}
+			}
+			h.Set([]byte(fmt.Sprintf("new.%s", key)), nil)
+			return true
+		})
+		if len(seen) != total || added > len(seen) {
+			t.Fatalf("Range visited %d of %d keys and %d new ones\n", len(seen), total, added)
+		}
+		if len(h.dead) != 0 || h.iters != 0 {
+			t.Fatalf("Removed entries were not released: %d\n", len(h.dead))
+		}
+		for k := range seen {
+			if h.Contains([]byte(k)) {
+				t.Fatalf("Key '%s' should have been removed\n", k)
+			}
+		}
+		if h.Count() != uint32(h.area.totalCnt-h.area.freeCnt) {
+			t.Fatalf("Entries in use %d vs %d elements\n", h.area.totalCnt-h.area.freeCnt, h.Count())
+		}
+		// The map grows again once the iteration is over.
+		h.Set(foo, nil)
+		h.Reserve(1 << 12)
+		if s := h.Stats(); int(s.NumElements) > 2*int(s.NumBuckets) {
+			t.Fatalf("HashMap did not resize after the iteration: %+v\n", s)
+		}
+	}
+}
+
+func TestRangeNested(t *testing.T) {
+	h := NewHashMap()
+	keys := parallelKeys(100)
+	for i, k := range keys {
+		h.Set(k, i)
+	}
+	n := 0
+	h.Range(func(k1 []byte, _ interface{}) bool {
+		h.Range(func(k2 []byte, _ interface{}) bool {
+			h.Remove(k2)
+			n++
+			return false
+		})
+		return true
+	})
+	if h.Count() != 0 || len(h.dead) != 0 || n > len(keys) {
+		t.Fatalf("Nested Range left %d elements, %d dead after %d calls\n", h.Count(), len(h.dead), n)
+	}
+}
+
+func TestRangeClearRemoveRandom(t *testing.T) {
+	h := NewHashCache()
+	keys := parallelKeys(100)
+	for i, k := range keys {
+		h.Set(k, i)
+	}
+	n := 0
+	h.Range(func([]byte, interface{}) bool {
+		h.RemoveRandom()
+		n++
+		return true
+	})
+	if n == 0 || int(h.Count())+n != len(keys) {
+		t.Fatalf("Range saw %d keys with %d left of %d\n", n, h.Count(), len(keys))
+	}
+	n = 0
+	h.Range(func([]byte, interface{}) bool {
+		h.Clear()
+		n++
+		return true
+	})
+	if n != 1 || h.Count() != 0 || h.area.freeCnt != h.area.totalCnt {
+		t.Fatalf("Clear during Range: %d calls, %d left\n", n, h.Count())
+	}
+}
+
+func TestScan(t *testing.T) {
+	h := NewHashMap()
+	keys := parallelKeys(1000)
+	for i, k := range keys {
+		h.Set(k, i)
+	}
+	seen := make(map[string]int)
+	cursor, calls := uint64(0), 0
+	for {
+		ks, vs, next := h.Scan(cursor, 7)
+		for i, k := range ks {
+			if vs[i].(int) != h.Get(k).(int) {
+				t.Fatalf("Scan paired '%s' with %v\n", k, vs[i])
+			}
+			seen[string(k)]++
+		}
+		calls++
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+	for _, k := range keys {
+		if seen[string(k)] != 1 {
+			t.Fatalf("Key '%s' returned %d times without resizing\n", k, seen[string(k)])
+		}
+	}
+	if calls < len(keys)/(7+3) {
+		t.Fatalf("Scan returned too much per call, %d calls\n", calls)
+	}
+}
+
+func TestScanResize(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithIncrementalRehash(1)},
+		{WithOptions(Options{GrowthFactor: 8, MinLoadFactor: 0.1})}} {
+		h := NewHashMap(opts...)
+		keys := parallelKeys(500)
+		for i, k := range keys {
+			h.Set(k, i)
+		}
+		seen := make(map[string]bool)
+		extra := 0
+		cursor := uint64(0)
+		for step := 0; ; step++ {
+			ks, _, next := h.Scan(cursor, 5)
+			for _, k := range ks {
+				seen[string(k)] = true
+			}
+			if cursor = next; cursor == 0 {
+				break
+			}
+			// Grow and shrink the map through several sizes
+			// between the calls.
+			switch step / 10 % 2 {
+			case 0:
+				for i := 0; i < 300; i++ {
+					h.Set([]byte(fmt.Sprintf("bar.%d", extra)), nil)
+					extra++
+				}
+			case 1:
+				for extra > 0 {
+					extra--
+					h.Remove([]byte(fmt.Sprintf("bar.%d", extra)))
+				}
+			}
+		}
+		for _, k := range keys {
+			if !seen[string(k)] {
+				t.Fatalf("Key '%s' was not returned by Scan\n", k)
+			}
+		}
+	}
+}
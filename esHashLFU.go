@@ -0,0 +1,231 @@
+// Frequency based eviction policies: LFU and W-TinyLFU.
+package esMap
+
+import "math/bits"
+
+// _LFUFREQS caps the use counts of PolicyLFU, so that the frequency
+// lists are a fixed array and every operation is O(1).
+const _LFUFREQS = 64
+
+// lfuEvictor is PolicyLFU. An entry used n times, as held by
+// Entry.stamp, is in list n, most recently used at the front.
+type lfuEvictor struct {
+	freqs [_LFUFREQS]entryList
+	// No entry is used fewer than min times. The lists up from
+	// min may be empty after removals, victim skips them.
+	min int
+}
+
+func newLFUEvictor() *lfuEvictor {
+	return &lfuEvictor{min: 1}
+}
+
+func (l *lfuEvictor) added(e *Entry) {
+	e.stamp = 1
+	l.freqs[1].pushFront(e)
+	l.min = 1
+}
+
+func (l *lfuEvictor) accessed(e *Entry) {
+	f := int(e.stamp)
+	if f == _LFUFREQS-1 {
+		l.freqs[f].moveToFront(e)
+		return
+	}
+	l.freqs[f].remove(e)
+	l.freqs[f+1].pushFront(e)
+	e.stamp++
+	if f == l.min && l.freqs[f].n == 0 {
+		l.min++
+	}
+}
+
+func (l *lfuEvictor) removed(e *Entry) {
+	l.freqs[e.stamp].remove(e)
+}
+
+func (l *lfuEvictor) victim(uint64) *Entry {
+	for ; l.min < _LFUFREQS; l.min++ {
+		if e := l.freqs[l.min].back; e != nil {
+			return e
+		}
+	}
+	l.min = 1
+	return nil
+}
+
+// Segments of W-TinyLFU, held by Entry.stamp.
+const (
+	_TLFUWINDOW = iota
+	_TLFUPROBATION
+	_TLFUPROTECTED
+)
+
+// tinyLFU is PolicyTinyLFU. New entries enter the window, 1% of the
+// capacity. The main segmented LRU is split in probation, for entries
+// used once in main, and protected, 80% of it, for entries used again.
+// When the cache is full, the LRU entry of the window and the LRU
+// entry of main compete, and the less frequent of them goes.
+type tinyLFU struct {
+	window, probation, protected entryList
+	wmax, mainMax, protMax       int
+	sketch                       cmSketch
+}
+
+func newTinyLFU(max int) *tinyLFU {
+	w := &tinyLFU{wmax: max / 100}
+	if w.wmax < 1 {
+		w.wmax = 1
+	}
+	w.mainMax = max - w.wmax
+	w.protMax = w.mainMax * 8 / 10
+	w.sketch.init(max)
+	return w
+}
+
+func (w *tinyLFU) list(e *Entry) *entryList {
+	switch e.stamp {
+	case _TLFUWINDOW:
+		return &w.window
+	case _TLFUPROBATION:
+		return &w.probation
+	}
+	return &w.protected
+}
+
+func (w *tinyLFU) added(e *Entry) {
+	w.sketch.add(e.hk)
+	e.stamp = _TLFUWINDOW
+	w.window.pushFront(e)
+	// While main has room, entries leaving the window need not
+	// compete for it.
+	if w.window.n > w.wmax && w.probation.n+w.protected.n < w.mainMax {
+		c := w.window.back
+		w.window.remove(c)
+		c.stamp = _TLFUPROBATION
+		w.probation.pushFront(c)
+	}
+}
+
+func (w *tinyLFU) accessed(e *Entry) {
+	w.sketch.add(e.hk)
+	switch e.stamp {
+	case _TLFUWINDOW:
+		w.window.moveToFront(e)
+	case _TLFUPROBATION:
+		w.probation.remove(e)
+		e.stamp = _TLFUPROTECTED
+		w.protected.pushFront(e)
+		if w.protected.n > w.protMax {
+			d := w.protected.back
+			w.protected.remove(d)
+			d.stamp = _TLFUPROBATION
+			w.probation.pushFront(d)
+		}
+	case _TLFUPROTECTED:
+		w.protected.moveToFront(e)
+	}
+}
+
+func (w *tinyLFU) removed(e *Entry) {
+	w.list(e).remove(e)
+}
+
+func (w *tinyLFU) victim(uint64) *Entry {
+	cand, victim := w.window.back, w.probation.back
+	if victim == nil {
+		victim = w.protected.back
+	}
+	switch {
+	case victim == nil:
+		return cand
+	case cand == nil || w.window.n < w.wmax:
+		return victim
+	}
+	// The admission: the candidate leaving the window replaces
+	// the victim of main only if it is more frequent.
+	if w.sketch.estimate(cand.hk) <= w.sketch.estimate(victim.hk) {
+		return cand
+	}
+	w.window.remove(cand)
+	cand.stamp = _TLFUPROBATION
+	w.probation.pushFront(cand)
+	return victim
+}
+
+// cmSketch is a Count-Min sketch of 4 rows of 4 bit counters, 16 to
+// a word. Once it counted 10 times as many uses as the cache holds
+// entries, all counters are halved, so that it follows changes of
+// popularity.
+type cmSketch struct {
+	table []uint64
+	msk   uint64 // counters per row minus 1
+	adds  int
+	reset int
+}
+
+// _CMROWS is the number of rows of a cmSketch.
+const _CMROWS = 4
+
+func (s *cmSketch) init(max int) {
+	width := 1 << bits.Len(uint(max-1))
+	if width < 16 {
+		width = 16
+	}
+	s.table = make([]uint64, _CMROWS*width/16)
+	s.msk = uint64(width - 1)
+	s.reset = 10 * max
+}
+
+// index returns the word and the shift of the counter of hk in row.
+func (s *cmSketch) index(hk uint64, row int) (int, uint) {
+	// Double hashing over a mixed hash, the hash of the map uses
+	// its low bits for the buckets already.
+	h1 := mix64(hk)
+	h2 := h1>>32 | 1
+	pos := uint64(row)*(s.msk+1) + (h1+uint64(row)*h2)&s.msk
+	return int(pos / 16), uint(pos%16) * 4
+}
+
+// add counts a use of hk.
+func (s *cmSketch) add(hk uint64) {
+	for row := 0; row < _CMROWS; row++ {
+		i, shift := s.index(hk, row)
+		if s.table[i]>>shift&0xf < 0xf {
+			s.table[i] += 1 << shift
+		}
+	}
+	if s.adds++; s.adds >= s.reset {
+		s.age()
+	}
+}
+
+// estimate returns the number of uses of hk, at most 15.
+func (s *cmSketch) estimate(hk uint64) uint64 {
+	min := uint64(0xf)
+	for row := 0; row < _CMROWS; row++ {
+		i, shift := s.index(hk, row)
+		if c := s.table[i] >> shift & 0xf; c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+// age halves all counters.
+func (s *cmSketch) age() {
+	for i, w := range s.table {
+		s.table[i] = w >> 1 & 0x7777777777777777
+	}
+	s.adds /= 2
+}
+
+// mix64 is the finalizer of SplitMix64.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
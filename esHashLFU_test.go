@@ -0,0 +1,154 @@
+package esMap
+
+import (
+	"testing"
+)
+
+func TestLFU(t *testing.T) {
+	ev := newEvicted()
+	h := NewHashCache(WithMaxEntries(4), WithPolicy(PolicyLFU), WithOnEvict(ev.onEvict))
+	keys := parallelKeys(8)
+	for _, k := range keys[:4] {
+		h.Set(k, nil)
+	}
+	// Uses: foo.0 3 times, foo.1 once, foo.2 twice, foo.3 twice,
+	// foo.2 used last.
+	for _, i := range []int{0, 0, 3, 2} {
+		h.Get(keys[i])
+	}
+	for i, exp := range []string{"foo.1", "foo.4", "foo.5"} {
+		h.Set(keys[4+i], nil)
+		if !ev.has(exp) || len(ev.keys) != i+1 {
+			t.Fatalf("Expected %s to be evicted, got %v\n", exp, ev.keys)
+		}
+	}
+	// foo.6 is the only entry used once, then foo.3 is the least
+	// recently used of those used twice.
+	h.Set(keys[7], nil)
+	h.Get(keys[7])
+	h.Set(keys[1], nil)
+	if !ev.has("foo.6") {
+		t.Fatalf("Expected foo.6 to be evicted, got %v\n", ev.keys)
+	}
+	h.Get(keys[1])
+	h.Set(keys[4], nil)
+	if !ev.has("foo.3") || h.Count() != 4 {
+		t.Fatalf("Expected foo.3 to be evicted, got %v\n", ev.keys)
+	}
+	// Counts saturate without overflowing the lists.
+	for i := 0; i < 2*_LFUFREQS; i++ {
+		h.Get(keys[0])
+	}
+	h.Remove(keys[0])
+	h.Clear()
+	l := h.ev.(*lfuEvictor)
+	for f := range l.freqs {
+		if l.freqs[f].n != 0 {
+			t.Fatalf("List %d holds %d entries of an empty cache\n", f, l.freqs[f].n)
+		}
+	}
+}
+
+func TestCountMinSketch(t *testing.T) {
+	var s cmSketch
+	s.init(1000)
+	for i := 0; i < 10; i++ {
+		s.add(1)
+	}
+	s.add(2)
+	if e1, e2, e3 := s.estimate(1), s.estimate(2), s.estimate(3); e1 != 10 || e2 != 1 || e3 != 0 {
+		t.Fatalf("Estimates %d, %d, %d vs 10, 1, 0\n", e1, e2, e3)
+	}
+	for i := 0; i < 20; i++ {
+		s.add(1)
+	}
+	if e := s.estimate(1); e != 15 {
+		t.Fatalf("Counters should saturate at 15, got %d\n", e)
+	}
+	// Count distinct keys until the sketch ages.
+	for hk, n := uint64(100), s.adds; s.adds >= n; hk++ {
+		n = s.adds
+		s.add(hk)
+	}
+	if e := s.estimate(1); e != 7 {
+		t.Fatalf("Aging should halve the counters, got %d\n", e)
+	}
+}
+
+func TestTinyLFUAdmission(t *testing.T) {
+	const size = 200
+	h := NewHashCache(WithMaxEntries(size), WithPolicy(PolicyTinyLFU))
+	hot := parallelKeys(size / 2)
+	for round := 0; round < 5; round++ {
+		for _, k := range hot {
+			if _, ok := h.Lookup(k); !ok {
+				h.Set(k, nil)
+			}
+		}
+	}
+	// A scan of keys used once must not flush the hot keys.
+	for _, k := range scanTraceKeys[:10*size] {
+		h.Set(k, nil)
+	}
+	kept := 0
+	for _, k := range hot {
+		if h.Contains(k) {
+			kept++
+		}
+	}
+	if kept < len(hot)*9/10 {
+		t.Fatalf("Only %d of %d hot keys survived a scan\n", kept, len(hot))
+	}
+	w := h.ev.(*tinyLFU)
+	if n := w.window.n + w.probation.n + w.protected.n; n != int(h.Count()) || w.protected.n > w.protMax {
+		t.Fatalf("Segments hold %d entries of %d, protected %d\n", n, h.Count(), w.protected.n)
+	}
+}
+
+func TestFrequencyHitRatio(t *testing.T) {
+	lru := hitRatio(PolicyLRU, _CACHESIZE, scanTraceKeys)
+	for _, p := range []Policy{PolicyLFU, PolicyTinyLFU} {
+		r := hitRatio(p, _CACHESIZE, scanTraceKeys)
+		if r <= lru {
+			t.Fatalf("Policy %v hit ratio %.3f should beat LRU %.3f on scans\n", p, r, lru)
+		}
+	}
+	random := hitRatio(PolicyRandom, _CACHESIZE, zipfTraceKeys)
+	if r := hitRatio(PolicyTinyLFU, _CACHESIZE, zipfTraceKeys); r <= random {
+		t.Fatalf("TinyLFU hit ratio %.3f should beat random %.3f on Zipf\n", r, random)
+	}
+}
+
+func TestRecordedHitRatio(t *testing.T) {
+	ratio := make(map[Policy]float64)
+	for _, p := range []Policy{PolicyRandom, PolicyLRU, PolicyLFU, PolicyTinyLFU} {
+		ratio[p] = hitRatio(p, _RECORDEDSIZE, nethttpTraceKeys)
+	}
+	// Identifiers come in bursts of the functions using them, so
+	// recency matters most: the use counts of LFU never fade and
+	// keep it behind random, aging and the window of TinyLFU make
+	// up for much of that but not all.
+	lru, random := ratio[PolicyLRU], ratio[PolicyRandom]
+	if lfu, tiny := ratio[PolicyLFU], ratio[PolicyTinyLFU]; lfu >= random || tiny <= lfu || tiny >= lru {
+		t.Fatalf("Hit ratios on net/http: %v\n", ratio)
+	}
+}
+
+func Benchmark_HashCache_Zipf_LFU(b *testing.B) {
+	benchmarkHitRatio(b, PolicyLFU, zipfTraceKeys)
+}
+func Benchmark_HashCache_Zipf_TinyLFU(b *testing.B) {
+	benchmarkHitRatio(b, PolicyTinyLFU, zipfTraceKeys)
+}
+func Benchmark_HashCache_Scan_LFU(b *testing.B) {
+	benchmarkHitRatio(b, PolicyLFU, scanTraceKeys)
+}
+func Benchmark_HashCache_Scan_TinyLFU(b *testing.B) {
+	benchmarkHitRatio(b, PolicyTinyLFU, scanTraceKeys)
+}
+func Benchmark_HashCache_NetHTTP_LFU(b *testing.B) {
+	benchmarkHitRatioSize(b, PolicyLFU, _RECORDEDSIZE, nethttpTraceKeys)
+}
+func Benchmark_HashCache_NetHTTP_TinyLFU(b *testing.B) {
+	benchmarkHitRatioSize(b, PolicyTinyLFU, _RECORDEDSIZE, nethttpTraceKeys)
+}
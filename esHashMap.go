@@ -9,19 +9,62 @@ package esMap
 import (
 	"bytes"
 	"errors"
+	"math"
+	"math/bits"
+	"reflect"
 	"unsafe"
 
-	"easy/esUtil/esHash"
+	"github.com/yireyun/go-map/hash"
 )
 
 // HashMap stores Entry items using a given Hash function.
-// The Hash function can be overridden.
+// The Hash function can be overridden. When a chain of the
+// default hash grows suspiciously long, the HashMap replaces it
+// with a keyed hash. A hash of the user's stays in use unless
+// asked for, see WithCollisionThreshold.
 type HashMap struct {
 	Hash func([]byte) uint32
-	bkts []*Entry
-	msk  uint32
-	used uint32
-	rsz  bool
+	// Hash64 switches the HashMap to 64 bit hashes when set, and
+	// Hash is not used then.
+	Hash64 func([]byte) uint64
+	bkts   []*Entry
+	msk    uint64
+	used   uint32
+	rsz    bool
+	keys   *keySlab
+	area   *EntryArea
+	// Incremental rehashing: while obkts is not nil, the entries of
+	// obkts[ridx:] have not been moved to bkts yet. Every operation
+	// moves rstep buckets.
+	obkts []*Entry
+	omsk  uint64
+	ridx  int
+	rstep int
+	// Growth policy, see Options. growAt and shrinkAt are the
+	// element counts for the current number of buckets.
+	maxLoad  float64
+	minLoad  float64
+	gshift   uint
+	minBkts  uint32
+	growAt   uint32
+	shrinkAt uint32
+	// HashDoS protection: a chain of dosAt entries makes the
+	// HashMap switch to a keyed hash, 0 is off. It only replaces
+	// the hash the HashMap was set up with, whose code is dosHash.
+	dosAt   int
+	dosHash uintptr
+	// Active iterations, and the entries removed during them which
+	// go back to the EntryArea once the last iteration ends.
+	iters int
+	dead  []*Entry
+	// Bounded capacity: inserting beyond max entries first evicts
+	// the victim of ev, which is told about every entry added,
+	// accessed and removed. See WithMaxEntries.
+	max     uint32
+	policy  Policy
+	samples int
+	ev      evictor
+	onEvict func(key []byte, data interface{}, reason EvictReason)
 }
 
 // BucketSize, must be power of 2
@@ -35,181 +78,467 @@ const (
 )
 
 // DefaultHash to be used unless overridden.
-var DefaultHash = esHash.Wukehong
+var DefaultHash = hash.Wukehong
 
-// Stats are reported on HashMaps
+// DefaultSeededHash is the seeded form of DefaultHash used by
+// WithSeed and WithRandomSeed.
+var DefaultSeededHash hash.SeededFunc = hash.WukehongSeed
+
+// Stats are reported on HashMaps. Open addressing maps report
+// the distance of entries from their home position as probes
+// instead of chains.
 type Stats struct {
 	NumElements uint32
 	NumSlots    uint32
 	NumBuckets  uint32
 	LongChain   uint32
 	AvgChain    float32
+	MaxProbe    uint32
+	AvgProbe    float32
+}
+
+// Map is the API shared by the map implementations of this package.
+type Map interface {
+	Set(key []byte, data interface{})
+	Get(key []byte) interface{}
+	Lookup(key []byte) (interface{}, bool)
+	Contains(key []byte) bool
+	Remove(key []byte) (interface{}, bool)
+	Count() uint32
+	AllKeys() [][]byte
+	All() []interface{}
+	Stats() *Stats
 }
 
+var (
+	_ Map = (*HashMap)(nil)
+	_ Map = (*ConcurrentHashMap)(nil)
+	_ Map = (*RCUHashMap)(nil)
+	_ Map = (*SwissMap)(nil)
+	_ Map = (*RobinHoodMap)(nil)
+	_ Map = (*CuckooMap)(nil)
+)
+
 // NewWithBkts creates a new HashMap using the bkts slice argument.
 // bkts must be a power of 2.
-func NewHashMapWithBkts(bkts int) (*HashMap, error) {
+func NewHashMapWithBkts(bkts int, opts ...Option) (*HashMap, error) {
 	if bkts == 0 || (bkts&(bkts-1) != 0) {
 		return nil, errors.New("Size of buckets must be power of 2")
 	}
 	h := HashMap{}
-	h.msk = uint32(bkts - 1)
-	h.bkts = make([]*Entry, bkts)
-	h.Hash = DefaultHash
-	h.rsz = true
+	h.init(make([]*Entry, bkts), opts)
 	return &h, nil
 }
 
 // New creates a new HashMap of default size and using the default
 // Hashing algorithm.
-func NewHashMap() *HashMap {
-	h, _ := NewHashMapWithBkts(_BSZ)
+func NewHashMap(opts ...Option) *HashMap {
+	h, _ := NewHashMapWithBkts(_BSZ, opts...)
 	return h
 }
 
 // Set will set the key item to data. This will blindly replace any item
 // that may have been at key previous.
 func (h *HashMap) Set(key []byte, data interface{}) {
-	hk := h.Hash(key)
-	e := h.bkts[hk&h.msk]
-	for e != nil {
-		if len(key) == len(e.key) && hk == e.hk && bytes.Equal(key, e.key) {
-			// Success, replace data field
-			e.data = data
-			return
+	h.set(h.hash(key), key, data)
+}
+
+// hash returns the hash of key, of 64 bits with Hash64 or 32 bits.
+func (h *HashMap) hash(key []byte) uint64 {
+	if h.Hash64 != nil {
+		return h.Hash64(key)
+	}
+	return uint64(h.Hash(key))
+}
+
+// set is Set for a key already hashed to hk.
+func (h *HashMap) set(hk uint64, key []byte, data interface{}) {
+	b, e, n := h.find(hk, key)
+	if *e != nil {
+		// Success, replace data field
+		(*e).data = data
+		h.touch(*e)
+		return
+	}
+	h.insert(b, n, hk, key, data)
+}
+
+// find walks the chain for key with hash hk. It returns the bucket,
+// the link to the Entry holding key, which is nil if key is missing,
+// and the number of entries in front of the link.
+func (h *HashMap) find(hk uint64, key []byte) (**Entry, **Entry, int) {
+	if h.obkts != nil {
+		h.rehash(h.rstep)
+	}
+	b := h.bucket(hk)
+	e := b
+	n := 0
+	for *e != nil {
+		if len(key) == len((*e).key) && hk == (*e).hk && bytes.Equal(key, (*e).key) {
+			break
 		}
-		e = e.next
+		e = &(*e).next
+		n++
+	}
+	return b, e, n
+}
+
+// insert adds key, which find did not find in bucket b with a chain
+// of n entries, with data.
+func (h *HashMap) insert(b **Entry, n int, hk uint64, key []byte, data interface{}) {
+	if h.dosAt > 0 && n >= h.dosAt && h.iters == 0 && h.hashPC() == h.dosHash {
+		// Far too many collisions for a decent hash, keys are
+		// likely crafted against it.
+		h.rekey()
+		hk = h.hash(key)
+		b = h.bucket(hk)
+	}
+	if h.max > 0 && h.used >= h.max {
+		// Make room first, evicting may shrink the buckets.
+		h.evict(h.ev.victim(hk), EvictCapacity)
+		b = h.bucket(hk)
 	}
 	// We have a new entry here
-	ne := &Entry{hk: hk, key: key, data: data}
-	ne.next = h.bkts[hk&h.msk]
-	h.bkts[hk&h.msk] = ne
+	if h.keys != nil {
+		key = h.keys.copy(key)
+	}
+	ne := h.area.Get()
+	ne.hk, ne.key, ne.data = hk, key, data
+	ne.next = *b
+	*b = ne
 	h.used += 1
+	if h.ev != nil {
+		h.ev.added(ne)
+	}
 	// Check for resizing
-	if h.rsz && (h.used > uint32(len(h.bkts))) {
+	if h.rsz && h.used > h.growAt {
 		h.grow()
 	}
 }
 
 // Get will return the item at key.
 func (h *HashMap) Get(key []byte) interface{} {
-	hk := h.Hash(key)
-	e := h.bkts[hk&h.msk]
+	if e := h.get(h.hash(key), key); e != nil {
+		return e.data
+	}
+	return nil
+}
+
+// Lookup will return the item at key and whether it was found, so
+// a stored nil can be told apart from a missing key.
+func (h *HashMap) Lookup(key []byte) (interface{}, bool) {
+	if e := h.get(h.hash(key), key); e != nil {
+		return e.data, true
+	}
+	return nil, false
+}
+
+// get is lookup counting as an access for the eviction policy.
+func (h *HashMap) get(hk uint64, key []byte) *Entry {
+	e := h.lookup(hk, key)
+	if h.ev != nil && e != nil {
+		h.ev.accessed(e)
+	}
+	return e
+}
+
+// Contains reports whether key is present in the HashMap. It does
+// not count as an access for the eviction policy.
+func (h *HashMap) Contains(key []byte) bool {
+	return h.lookup(h.hash(key), key) != nil
+}
+
+// lookup returns the Entry holding key with hash hk, or nil.
+func (h *HashMap) lookup(hk uint64, key []byte) *Entry {
+	if h.obkts != nil {
+		h.rehash(h.rstep)
+	}
+	e := *h.bucket(hk)
 	// FIXME: Reorder on GET if chained?
 	// We unroll and optimize the comparison of keys.
 	for e != nil {
-		klen := len(key)
-		p1 := uintptr(unsafe.Pointer(&key[0]))
-		p2 := uintptr(unsafe.Pointer(&e.key[0]))
+		var p1, p2 unsafe.Pointer
+		klen, i := len(key), 0
 		if klen != len(e.key) || hk != e.hk {
 			goto next
 		}
+		// Empty keys have no bytes to compare, and taking
+		// the address of their first byte would panic.
+		if klen == 0 {
+			return e
+		}
+		p1 = unsafe.Pointer(&key[0])
+		p2 = unsafe.Pointer(&e.key[0])
 		if p1 != p2 {
 			// We unroll and optimize the key comparison here.
+			// Every read is at offset i with i+size <= len(key),
+			// so we never touch memory beyond the slices.
 			// Compare _DWSZ at a time
 			for ; klen >= _DWSZ; klen -= _DWSZ {
-				k1 := *(*uint64)(unsafe.Pointer(p1))
-				k2 := *(*uint64)(unsafe.Pointer(p2))
+				k1 := *(*uint64)(unsafe.Add(p1, i))
+				k2 := *(*uint64)(unsafe.Add(p2, i))
 				if k1 != k2 {
 					goto next
 				}
-				p1 += _DWSZ
-				p2 += _DWSZ
+				i += _DWSZ
 			}
 			// Check by _WSZ if applicable
 			if (klen & _WSZ) > 0 {
-				k1 := *(*uint32)(unsafe.Pointer(p1))
-				k2 := *(*uint32)(unsafe.Pointer(p2))
+				k1 := *(*uint32)(unsafe.Add(p1, i))
+				k2 := *(*uint32)(unsafe.Add(p2, i))
 				if k1 != k2 {
 					goto next
 				}
-				p1 += _WSZ
-				p2 += _WSZ
+				i += _WSZ
 			}
 			// Check by _DSZ if applicable
 			if (klen & _DSZ) > 0 {
-				k1 := *(*uint16)(unsafe.Pointer(p1))
-				k2 := *(*uint16)(unsafe.Pointer(p2))
+				k1 := *(*uint16)(unsafe.Add(p1, i))
+				k2 := *(*uint16)(unsafe.Add(p2, i))
 				if k1 != k2 {
 					goto next
 				}
-				p1 += _DSZ
-				p2 += _DSZ
+				i += _DSZ
 			}
 			// Check by byte if applicable
 			if (klen & 1) > 0 {
-				k1 := *(*uint8)(unsafe.Pointer(p1))
-				k2 := *(*uint8)(unsafe.Pointer(p2))
+				k1 := *(*uint8)(unsafe.Add(p1, i))
+				k2 := *(*uint8)(unsafe.Add(p2, i))
 				if k1 != k2 {
 					goto next
 				}
 			}
 		}
 		// Success
-		return e.data
+		return e
 	next:
 		e = e.next
 	}
 	return nil
 }
 
-// Remove will remove what is associated with key.
-func (h *HashMap) Remove(key []byte) {
-	hk := h.Hash(key)
-	e := &h.bkts[hk&h.msk]
-	for *e != nil {
-		if len(key) == len((*e).key) && hk == (*e).hk && bytes.Equal(key, (*e).key) {
-			// Success
-			*e = (*e).next
-			h.used -= 1
-			// Check for resizing
-			lbkts := uint32(len(h.bkts))
-			if h.rsz && lbkts > _BSZ && (h.used < lbkts>>2) {
-				h.shrink()
-			}
-			return
+// Remove will remove what is associated with key. It returns the
+// removed item and whether anything was removed.
+func (h *HashMap) Remove(key []byte) (interface{}, bool) {
+	return h.remove(h.hash(key), key)
+}
+
+// remove is Remove for a key already hashed to hk.
+func (h *HashMap) remove(hk uint64, key []byte) (interface{}, bool) {
+	_, e, _ := h.find(hk, key)
+	if *e == nil {
+		return nil, false
+	}
+	return h.unlink(e), true
+}
+
+// unlink removes the Entry at link e and returns its data.
+func (h *HashMap) unlink(e **Entry) interface{} {
+	re := *e
+	data := re.data
+	*e = re.next
+	h.free(re)
+	h.used -= 1
+	if h.keys != nil && h.keys.sparse() {
+		h.compactKeys()
+	}
+	// Check for resizing
+	if h.rsz && h.used < h.shrinkAt {
+		h.shrink()
+	}
+	return data
+}
+
+// bucket returns the bucket holding the chain for hash hk. While
+// rehashing, that is the old bucket until it has been moved.
+func (h *HashMap) bucket(hk uint64) **Entry {
+	if h.obkts != nil && int(hk&h.omsk) >= h.ridx {
+		return &h.obkts[hk&h.omsk]
+	}
+	return &h.bkts[hk&h.msk]
+}
+
+// rehash moves up to n buckets of an incremental rehash
+// from the old to the new buckets. Iterations hold it off.
+func (h *HashMap) rehash(n int) {
+	if h.iters > 0 {
+		return
+	}
+	for ; n > 0 && h.ridx < len(h.obkts); n-- {
+		e := h.obkts[h.ridx]
+		h.obkts[h.ridx] = nil
+		h.ridx++
+		for e != nil {
+			ne := e
+			e = e.next
+			ne.next = h.bkts[ne.hk&h.msk]
+			h.bkts[ne.hk&h.msk] = ne
 		}
-		e = &(*e).next
+	}
+	if h.ridx >= len(h.obkts) {
+		h.obkts = nil
+		h.ridx = 0
+	}
+}
+
+// finishRehash completes an incremental rehash in progress.
+func (h *HashMap) finishRehash() {
+	if h.obkts != nil {
+		h.rehash(len(h.obkts))
 	}
 }
 
 // resize is responsible for reallocating the buckets and
-// redistributing the hashmap entries.
-func (h *HashMap) resize(nsz uint32) {
+// redistributing the hashmap entries. Entries are relinked in
+// place, they keep living in the blocks of the EntryArea. With
+// incremental rehashing the entries are moved later, a few
+// buckets per operation. Iterations hold off resizing.
+func (h *HashMap) resize(nsz uint64) {
+	if h.iters > 0 {
+		return
+	}
+	h.finishRehash()
 	nmsk := nsz - 1
 	bkts := make([]*Entry, nsz)
-	ents := make([]Entry, h.used)
-	var ne *Entry
-	var i int
+	defer h.setThresholds()
+	if h.rstep > 0 {
+		h.obkts, h.omsk, h.ridx = h.bkts, h.msk, 0
+		h.bkts, h.msk = bkts, nmsk
+		return
+	}
 	for _, e := range h.bkts {
-		for ; e != nil; e = e.next {
-			ne, i = &ents[i], i+1
-			*ne = *e
-			ne.next = bkts[e.hk&nmsk]
-			bkts[e.hk&nmsk] = ne
+		for e != nil {
+			ne := e
+			e = e.next
+			ne.next = bkts[ne.hk&nmsk]
+			bkts[ne.hk&nmsk] = ne
 		}
 	}
 	h.bkts = bkts
 	h.msk = nmsk
 }
 
+// hashPC returns the code pointer of the hash in use, which tells
+// whether the Hash field was overridden after setting up the HashMap.
+func (h *HashMap) hashPC() uintptr {
+	if h.Hash64 != nil {
+		return reflect.ValueOf(h.Hash64).Pointer()
+	}
+	return reflect.ValueOf(h.Hash).Pointer()
+}
+
+// rekey switches the HashMap to a keyed hash with a random seed of
+// its own and rehashes every entry. It disables further switching.
+// The Entry.hk of every entry changes, so the eviction policies lose
+// what they remember by hash: the ghost lists of ARC, 2Q and S3-FIFO
+// and the frequencies of the TinyLFU sketch.
+func (h *HashMap) rekey() {
+	h.finishRehash()
+	mh := hash.NewMapHasher()
+	if h.Hash64 != nil {
+		h.Hash64 = mh.Sum64
+	} else {
+		h.Hash = mh.Sum32
+	}
+	h.dosAt = 0
+	bkts := make([]*Entry, len(h.bkts))
+	for _, e := range h.bkts {
+		for e != nil {
+			ne := e
+			e = e.next
+			ne.hk = h.hash(ne.key)
+			ne.next = bkts[ne.hk&h.msk]
+			bkts[ne.hk&h.msk] = ne
+		}
+	}
+	h.bkts = bkts
+}
+
 const maxBktSize = (1 << 31) - 1
 
-// grow the HashMap's buckets by 2
+// maxBktSize64 caps the buckets with 64 bit hashes at 2^32, as many
+// as the 32 bit element counts fill at the default load factor.
+// More buckets would need wider counters.
+const maxBktSize64 = (1 << 32) - 1
+
+// maxBkts returns the most buckets for the hash size in use. 32 bit
+// hashes could not spread entries over more buckets.
+func (h *HashMap) maxBkts() uint64 {
+	if h.Hash64 != nil {
+		return maxBktSize64
+	}
+	return maxBktSize
+}
+
+// setThresholds computes the element counts at which the HashMap
+// grows and shrinks for its current number of buckets.
+func (h *HashMap) setThresholds() {
+	l := float64(len(h.bkts))
+	h.growAt = uint32(math.Min(h.maxLoad*l, math.MaxUint32))
+	h.shrinkAt = uint32(h.minLoad * l)
+}
+
+// grow the HashMap's buckets by the growth factor, 2 by default
 func (h *HashMap) grow() {
-	// Can't grow beyond maxint for now
-	if len(h.bkts) >= maxBktSize {
+	max := h.maxBkts()
+	if uint64(len(h.bkts)) >= max {
 		return
 	}
-	h.resize(uint32(len(h.bkts) << 1))
+	nsz := uint64(len(h.bkts)) << h.gshift
+	if nsz > max+1 {
+		nsz = max + 1
+	}
+	h.resize(nsz)
 }
 
 // shrink the HashMap's buckets by 2
 func (h *HashMap) shrink() {
-	if len(h.bkts) <= _BSZ {
+	if uint32(len(h.bkts)) <= h.minBkts {
+		return
+	}
+	h.resize(uint64(len(h.bkts) >> 1))
+}
+
+// Reserve makes room for n elements, growing the buckets at once so
+// that a bulk load of n elements does not resize along the way.
+// Removing elements may shrink the HashMap again.
+func (h *HashMap) Reserve(n int) {
+	nsz := uint64(math.Ceil(float64(n) / h.maxLoad))
+	if max := h.maxBkts(); nsz > max {
+		nsz = max
+	}
+	if nsz <= uint64(len(h.bkts)) {
 		return
 	}
-	h.resize(uint32(len(h.bkts) >> 1))
+	// Resize at once, a reservation should not be amortized.
+	rstep := h.rstep
+	h.rstep = 0
+	h.resize(uint64(1) << bits.Len64(nsz-1))
+	h.rstep = rstep
+}
+
+// Clear removes all elements. The buckets keep their size and
+// the entries are returned to the EntryArea for reuse. The OnEvict
+// callback sees every element with EvictClear.
+func (h *HashMap) Clear() {
+	for _, bkts := range h.chains() {
+		for i, e := range bkts {
+			for e != nil {
+				ne := e
+				e = e.next
+				if h.onEvict != nil {
+					h.onEvict(ne.key, ne.data, EvictClear)
+				}
+				h.free(ne)
+			}
+			bkts[i] = nil
+		}
+	}
+	h.obkts, h.ridx = nil, 0
+	h.used = 0
+	if h.keys != nil {
+		*h.keys = keySlab{}
+	}
 }
 
 // Count returns number of elements in the HashMap
@@ -217,12 +546,23 @@ func (h *HashMap) Count() uint32 {
 	return h.used
 }
 
+// chains returns the bucket slices holding entries, which are
+// the old buckets not moved yet as well while rehashing.
+func (h *HashMap) chains() [2][]*Entry {
+	if h.obkts != nil {
+		return [2][]*Entry{h.bkts, h.obkts[h.ridx:]}
+	}
+	return [2][]*Entry{h.bkts}
+}
+
 // AllKeys will return all the keys stored in the HashMap
 func (h *HashMap) AllKeys() [][]byte {
 	all := make([][]byte, 0, h.used)
-	for _, e := range h.bkts {
-		for ; e != nil; e = e.next {
-			all = append(all, e.key)
+	for _, bkts := range h.chains() {
+		for _, e := range bkts {
+			for ; e != nil; e = e.next {
+				all = append(all, e.key)
+			}
 		}
 	}
 	return all
@@ -231,31 +571,36 @@ func (h *HashMap) AllKeys() [][]byte {
 // All returns all the Entries in the map
 func (h *HashMap) All() []interface{} {
 	all := make([]interface{}, 0, h.used)
-	for _, e := range h.bkts {
-		for ; e != nil; e = e.next {
-			all = append(all, e.data)
+	for _, bkts := range h.chains() {
+		for _, e := range bkts {
+			for ; e != nil; e = e.next {
+				all = append(all, e.data)
+			}
 		}
 	}
 	return all
 }
 
-// Stats will collect general statistics about the HashMap
+// Stats will collect general statistics about the HashMap.
+// While rehashing, chains of both bucket arrays are counted.
 func (h *HashMap) Stats() *Stats {
 	lc, totalc, slots := 0, 0, 0
-	for _, e := range h.bkts {
-		if e != nil {
-			slots += 1
-		}
-		i := 0
-		for ; e != nil; e = e.next {
-			i += 1
-			if i > lc {
-				lc = i
+	for _, bkts := range h.chains() {
+		for _, e := range bkts {
+			if e != nil {
+				slots += 1
+			}
+			i := 0
+			for ; e != nil; e = e.next {
+				i += 1
+				if i > lc {
+					lc = i
+				}
 			}
+			totalc += i
 		}
-		totalc += i
 	}
-	l := uint32(len(h.bkts))
+	l := uint32(math.Min(float64(len(h.bkts)), math.MaxUint32))
 	avg := (float32(totalc) / float32(slots))
 	return &Stats{
 		NumElements: h.used,
@@ -265,6 +610,12 @@ func (h *HashMap) Stats() *Stats {
 		NumSlots:    uint32(slots)}
 }
 
+// EntryStats will collect statistics about the allocator of the
+// HashMap's entries.
+func (h *HashMap) EntryStats() *EntryAreaStats {
+	return h.area.Stats()
+}
+
 func SilceEqui(b1, b2 []byte) bool {
 	i, klen := 0, len(b1)
 	if klen != len(b2) {
@@ -6,7 +6,10 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	mrand "math/rand"
+	"sort"
 	"testing"
+	"time"
 )
 
 func TestMapWithBkts(t *testing.T) {
@@ -239,6 +242,320 @@ func TestFalseLookup(t *testing.T) {
 	}
 }
 
+func TestLookupStoredNil(t *testing.T) {
+	h := NewHashMap()
+	h.Set(foo, nil)
+	if v, ok := h.Lookup(foo); !ok || v != nil {
+		t.Fatalf("Expected stored nil to be found, got %v, %v\n", v, ok)
+	}
+	if v, ok := h.Lookup(bar); ok || v != nil {
+		t.Fatalf("Expected missing key, got %v, %v\n", v, ok)
+	}
+	if !h.Contains(foo) {
+		t.Fatalf("Expected Contains(foo) to be true\n")
+	}
+	if h.Contains(bar) {
+		t.Fatalf("Expected Contains(bar) to be false\n")
+	}
+}
+
+func TestRemoveReturnsOld(t *testing.T) {
+	h := NewHashMap()
+	h.Set(foo, "1")
+	h.Set(bar, nil)
+	if v, ok := h.Remove(foo); !ok || v.(string) != "1" {
+		t.Fatalf("Expected to remove '1', got %v, %v\n", v, ok)
+	}
+	if v, ok := h.Remove(foo); ok || v != nil {
+		t.Fatalf("Expected nothing to remove, got %v, %v\n", v, ok)
+	}
+	if v, ok := h.Remove(bar); !ok || v != nil {
+		t.Fatalf("Expected to remove stored nil, got %v, %v\n", v, ok)
+	}
+	if h.Count() != 0 {
+		t.Fatalf("Wrong number of entries: %d vs 0\n", h.Count())
+	}
+}
+
+func TestEmptyKey(t *testing.T) {
+	h := NewHashMap()
+	// Force every key into the same chain so Get has to compare
+	// against empty keys as well as find them.
+	h.Hash = func([]byte) uint32 { return 0 }
+	h.Set(foo, "foo")
+	h.Set([]byte{}, "empty")
+	h.Set(bar, "bar")
+	if v := h.Get(nil); v == nil || v.(string) != "empty" {
+		t.Fatalf("Expected nil key to find empty key, got %v\n", v)
+	}
+	if v := h.Get([]byte{}); v == nil || v.(string) != "empty" {
+		t.Fatalf("Expected empty key, got %v\n", v)
+	}
+	for _, k := range [][]byte{foo, bar} {
+		if v := h.Get(k); v == nil || v.(string) != string(k) {
+			t.Fatalf("Expected '%s', got %v\n", k, v)
+		}
+	}
+	h.Set(nil, "nil")
+	if h.Count() != 3 {
+		t.Fatalf("nil and empty keys should be the same, %d vs 3\n", h.Count())
+	}
+	found := false
+	for _, k := range h.AllKeys() {
+		if len(k) == 0 {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("AllKeys did not return the empty key\n")
+	}
+	if v, ok := h.Remove([]byte{}); !ok || v.(string) != "nil" {
+		t.Fatalf("Expected to remove empty key, got %v, %v\n", v, ok)
+	}
+	if h.Contains(nil) {
+		t.Fatalf("Empty key should have been removed\n")
+	}
+	if v := h.Get(foo); v == nil || v.(string) != "foo" {
+		t.Fatalf("Expected 'foo', got %v\n", v)
+	}
+}
+
+// FuzzHashMapGet checks Get against a Go map for keys of any length.
+// The stored keys and the lookup keys are prefixes of buffers which
+// agree on the prefix but differ right after it, so reading beyond
+// the bounds of a key shows up as a missed or false match.
+func FuzzHashMapGet(f *testing.F) {
+	f.Add([]byte(""), []byte("a"))
+	f.Add([]byte("cache.test.0"), []byte("cache.test.1"))
+	f.Add([]byte("cache.test.1234"), []byte("cache.test.0000"))
+	f.Add(sub, med)
+	f.Fuzz(func(t *testing.T, k1, k2 []byte) {
+		h := NewHashMap()
+		h.Hash = func(b []byte) uint32 { return uint32(len(b)) }
+		ref := make(map[string]int)
+		keys := [][]byte{k1, k2}
+		for i, k := range keys {
+			for n := 0; n <= len(k); n++ {
+				buf := append(append([]byte{}, k[:n]...), 0x55, 0xaa)
+				h.Set(buf[:n], i)
+				ref[string(k[:n])] = i
+			}
+		}
+		for _, k := range keys {
+			for n := 0; n <= len(k); n++ {
+				buf := append(append([]byte{}, k[:n]...), 0xaa, 0x55)
+				v, ok := h.Lookup(buf[:n])
+				if !ok || v.(int) != ref[string(k[:n])] {
+					t.Fatalf("Lookup(%q) = %v, %v vs %d\n", k[:n], v, ok, ref[string(k[:n])])
+				}
+			}
+		}
+		if int(h.Count()) != len(ref) {
+			t.Fatalf("Wrong number of entries: %d vs %d\n", h.Count(), len(ref))
+		}
+	})
+}
+
+func TestKeyCopy(t *testing.T) {
+	h := NewHashMap(WithKeyCopy())
+	buf := make([]byte, 0, 64)
+	for i := 0; i < INS; i++ {
+		// Reuse the same buffer for every key, like a network reader
+		buf = append(buf[:0], fmt.Sprintf("foo.bar.%d", i)...)
+		h.Set(buf, i)
+	}
+	buf = append(buf[:0], "garbage"...)
+	for i := 0; i < INS; i++ {
+		k := []byte(fmt.Sprintf("foo.bar.%d", i))
+		if v, ok := h.Lookup(k); !ok || v.(int) != i {
+			t.Fatalf("Lookup('%s') = %v, %v vs %d\n", k, v, ok, i)
+		}
+	}
+	for _, k := range h.AllKeys() {
+		if len(k) != cap(k) {
+			t.Fatalf("Owned key capacity not clipped: %d vs %d\n", cap(k), len(k))
+		}
+	}
+	// Replacing keeps the owned key
+	h.Set(buf, "g")
+	h.Set(buf, "g")
+	if h.Count() != INS+1 {
+		t.Fatalf("Wrong number of entries: %d vs %d\n", h.Count(), INS+1)
+	}
+}
+
+func TestKeySlab(t *testing.T) {
+	s := new(keySlab)
+	k1 := s.copy(foo)
+	k2 := s.copy(bar)
+	if &s.buf[0] != &k1[0] || &s.buf[len(k1)] != &k2[0] {
+		t.Fatalf("Small keys should be packed in the same chunk\n")
+	}
+	k3 := s.copy(bytes.Repeat(foo, _KSLABMAX))
+	if len(k3) != 3*_KSLABMAX || !bytes.Equal(k3[:3], foo) {
+		t.Fatalf("Large key copied incorrectly\n")
+	}
+	if k := s.copy(nil); k == nil || len(k) != 0 {
+		t.Fatalf("Empty key should be copied to an empty, non-nil slice\n")
+	}
+	k1 = append(k1, 'x')
+	if !bytes.Equal(k2, bar) {
+		t.Fatalf("Appending to a key must not overwrite its neighbour\n")
+	}
+}
+
+func TestKeySlabChurn(t *testing.T) {
+	const live = 1000
+	h := NewHashMap(WithKeyCopy())
+	h.Set([]byte("held"), nil)
+	held := h.AllKeys()[0]
+	key := func(i int) []byte { return []byte(fmt.Sprintf("churn.%d", i)) }
+	for i := 0; i < 500*live; i++ {
+		h.Set(key(i), i)
+		if i >= live {
+			h.Remove(key(i - live))
+		}
+		// Removed keys never take more than half the slab for long.
+		if s := h.keys; s.size > _KSLABGC+2*s.live+_KSLAB {
+			t.Fatalf("Slab of %d bytes for %d bytes of keys\n", s.size, s.live)
+		}
+	}
+	n := len("held")
+	for i := 500*live - live; i < 500*live; i++ {
+		k := key(i)
+		n += len(k)
+		if v, ok := h.Lookup(k); !ok || v.(int) != i {
+			t.Fatalf("Lookup('%s') = %v, %v vs %d\n", k, v, ok, i)
+		}
+	}
+	if h.keys.live != n {
+		t.Fatalf("Slab counts %d bytes of keys vs %d\n", h.keys.live, n)
+	}
+	// Keys handed out before compacting are never overwritten.
+	if string(held) != "held" || !h.Contains(held) {
+		t.Fatalf("Key handed out was overwritten: '%s'\n", held)
+	}
+	h.Clear()
+	if h.keys.size != 0 || h.keys.live != 0 {
+		t.Fatalf("Clear left a slab of %d bytes\n", h.keys.size)
+	}
+}
+
+func TestEntriesPooled(t *testing.T) {
+	h := NewHashMap()
+	var toks [INS][]byte
+	for i := range toks {
+		toks[i] = []byte(fmt.Sprintf("foo.%d", i))
+		h.Set(toks[i], boxed)
+	}
+	if used := h.area.totalCnt - h.area.freeCnt; used != INS {
+		t.Fatalf("Wrong number of entries in use: %d vs %d\n", used, INS)
+	}
+	for i := range toks {
+		h.Remove(toks[i])
+	}
+	if h.area.totalCnt != h.area.freeCnt {
+		t.Fatalf("Entries not returned: %d free of %d\n", h.area.freeCnt, h.area.totalCnt)
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		h.Set(foo, boxed)
+		h.Remove(foo)
+	})
+	if allocs != 0 {
+		t.Fatalf("Set and Remove should not allocate, got %v allocs\n", allocs)
+	}
+}
+
+func TestIncrementalRehash(t *testing.T) {
+	h := NewHashMap(WithIncrementalRehash(1))
+	var toks [INS][]byte
+	for i := range toks {
+		toks[i] = []byte(fmt.Sprintf("foo.%d", i))
+		h.Set(toks[i], i)
+		// Everything inserted so far must be found mid-rehash
+		for j := 0; j <= i; j++ {
+			if v, ok := h.Lookup(toks[j]); !ok || v.(int) != j {
+				t.Fatalf("Lookup('%s') = %v, %v vs %d\n", toks[j], v, ok, j)
+			}
+		}
+	}
+	if len(h.bkts) != EXP {
+		t.Fatalf("Expanded bucket size is wrong: %d vs %d\n", len(h.bkts), EXP)
+	}
+	// Growing from 64 to 128 buckets at insert 65 leaves 36
+	// inserts plus 2550 lookups, enough to finish moving.
+	if h.obkts != nil {
+		t.Fatalf("Rehash should have completed\n")
+	}
+	for i := 0; i < REM; i++ {
+		if _, ok := h.Remove(toks[i]); !ok {
+			t.Fatalf("Failed to remove '%s'\n", toks[i])
+		}
+	}
+	if len(h.bkts) != EXP2 {
+		t.Fatalf("Shrunk bucket size is wrong: %d vs %d\n", len(h.bkts), EXP2)
+	}
+	for i := REM; i < INS; i++ {
+		if v := h.Get(toks[i]); v == nil || v.(int) != i {
+			t.Fatalf("Did not match properly, %v vs %d\n", v, i)
+		}
+	}
+}
+
+func TestIncrementalRehashMidway(t *testing.T) {
+	h := NewHashMap(WithIncrementalRehash(1))
+	for i := 0; i <= _BSZ; i++ {
+		h.Set([]byte(fmt.Sprintf("foo.%d", i)), i)
+	}
+	// The insert that grows only starts the rehash
+	if h.obkts == nil || len(h.bkts) != 2*_BSZ || h.ridx != 0 {
+		t.Fatalf("Expected a rehash in progress, ridx %d of %d\n", h.ridx, len(h.obkts))
+	}
+	if n := len(h.AllKeys()); n != _BSZ+1 {
+		t.Fatalf("AllKeys mid-rehash returned %d vs %d\n", n, _BSZ+1)
+	}
+	if n := len(h.All()); n != _BSZ+1 {
+		t.Fatalf("All mid-rehash returned %d vs %d\n", n, _BSZ+1)
+	}
+	if s := h.Stats(); s.NumBuckets != 2*_BSZ {
+		t.Fatalf("Stats mid-rehash incorrect: %+v\n", s)
+	}
+	h.Get(foo)
+	if h.ridx != 1 {
+		t.Fatalf("Get should move a bucket, ridx %d vs 1\n", h.ridx)
+	}
+	// Compare a long run of random operations with a Go map
+	ref := make(map[string]int)
+	for i := 0; i <= _BSZ; i++ {
+		ref[fmt.Sprintf("foo.%d", i)] = i
+	}
+	r := mrand.New(mrand.NewSource(1))
+	for i := 0; i < 100000; i++ {
+		k := fmt.Sprintf("foo.%d", r.Intn(1000))
+		switch r.Intn(3) {
+		case 0:
+			h.Set([]byte(k), i)
+			ref[k] = i
+		case 1:
+			_, ok1 := h.Remove([]byte(k))
+			_, ok2 := ref[k]
+			delete(ref, k)
+			if ok1 != ok2 {
+				t.Fatalf("Remove('%s') = %v vs %v\n", k, ok1, ok2)
+			}
+		case 2:
+			v, ok1 := h.Lookup([]byte(k))
+			rv, ok2 := ref[k]
+			if ok1 != ok2 || ok1 && v.(int) != rv {
+				t.Fatalf("Lookup('%s') = %v, %v vs %v, %v\n", k, v, ok1, rv, ok2)
+			}
+		}
+		if h.Count() != uint32(len(ref)) {
+			t.Fatalf("Wrong number of entries: %d vs %d\n", h.Count(), len(ref))
+		}
+	}
+}
+
 func TestRemoveRandom(t *testing.T) {
 	h := NewHashCache()
 	h.RemoveRandom()
@@ -258,8 +575,13 @@ func TestRemoveRandom(t *testing.T) {
 	}
 }
 
+// isPrint enables dumping of the bucket distribution in benchmarks.
+const isPrint = false
+
 func Print(m *HashMap) {
-	return
+	if !isPrint {
+		return
+	}
 	s := "\t"
 	cnt := 0
 	for i := 0; i < len(m.bkts); i++ {
@@ -393,6 +715,60 @@ func benchmark_HashMap__GetLrgKey(b *testing.B, size int) {
 	}
 }
 
+func benchmark_SwissMap_GetSmallKey(b *testing.B, size int) {
+	b.StopTimer()
+	m := NewSwissMap()
+	keys := make([][]byte, size)
+	for i := 0; i < len(keys); i++ {
+		keys[i] = []byte(fmt.Sprintf("foo.%d", i))
+		m.Set(keys[i], bar)
+	}
+	b.StartTimer()
+
+	Grp := b.N / size
+	for g := 0; g < Grp; g++ {
+		for i := 0; i < size; i++ {
+			_ = m.Get(keys[i])
+		}
+	}
+}
+
+func benchmark_SwissMap__GetMedKey(b *testing.B, size int) {
+	b.StopTimer()
+	m := NewSwissMap()
+	keys := make([][]byte, size)
+	for i := 0; i < len(keys); i++ {
+		keys[i] = []byte(fmt.Sprintf("%s.%d", med, i))
+		m.Set(keys[i], bar)
+	}
+	b.StartTimer()
+
+	Grp := b.N / size
+	for g := 0; g < Grp; g++ {
+		for i := 0; i < size; i++ {
+			_ = m.Get(keys[i])
+		}
+	}
+}
+
+func benchmark_SwissMap__GetLrgKey(b *testing.B, size int) {
+	b.StopTimer()
+	m := NewSwissMap()
+	keys := make([][]byte, size)
+	for i := 0; i < len(keys); i++ {
+		keys[i] = []byte(fmt.Sprintf("%s.%d", sub, i))
+		m.Set(keys[i], bar)
+	}
+	b.StartTimer()
+
+	Grp := b.N / size
+	for g := 0; g < Grp; g++ {
+		for i := 0; i < size; i++ {
+			_ = m.Get(keys[i])
+		}
+	}
+}
+
 func Benchmark_GoMap___GetSmallKey___8(b *testing.B) {
 	benchmark_GoMap___GetSmallKey(b, 8)
 }
@@ -411,6 +787,15 @@ func Benchmark_GoMap____GetLrgKey____8(b *testing.B) {
 func Benchmark_HashMap__GetLrgKey____8(b *testing.B) {
 	benchmark_HashMap__GetLrgKey(b, 8)
 }
+func Benchmark_SwissMap_GetSmallKey____8(b *testing.B) {
+	benchmark_SwissMap_GetSmallKey(b, 8)
+}
+func Benchmark_SwissMap__GetMedKey____8(b *testing.B) {
+	benchmark_SwissMap__GetMedKey(b, 8)
+}
+func Benchmark_SwissMap__GetLrgKey____8(b *testing.B) {
+	benchmark_SwissMap__GetLrgKey(b, 8)
+}
 
 func Benchmark_GoMap___GetSmallKey_16(b *testing.B) {
 	benchmark_GoMap___GetSmallKey(b, 16)
@@ -430,6 +815,15 @@ func Benchmark_GoMap____GetLrgKey__16(b *testing.B) {
 func Benchmark_HashMap__GetLrgKey__16(b *testing.B) {
 	benchmark_HashMap__GetLrgKey(b, 16)
 }
+func Benchmark_SwissMap_GetSmallKey__16(b *testing.B) {
+	benchmark_SwissMap_GetSmallKey(b, 16)
+}
+func Benchmark_SwissMap__GetMedKey__16(b *testing.B) {
+	benchmark_SwissMap__GetMedKey(b, 16)
+}
+func Benchmark_SwissMap__GetLrgKey__16(b *testing.B) {
+	benchmark_SwissMap__GetLrgKey(b, 16)
+}
 
 func Benchmark_GoMap___GetSmallKey__32(b *testing.B) {
 	benchmark_GoMap___GetSmallKey(b, 32)
@@ -449,6 +843,15 @@ func Benchmark_GoMap____GetLrgKey___32(b *testing.B) {
 func Benchmark_HashMap__GetLrgKey___32(b *testing.B) {
 	benchmark_HashMap__GetLrgKey(b, 32)
 }
+func Benchmark_SwissMap_GetSmallKey___32(b *testing.B) {
+	benchmark_SwissMap_GetSmallKey(b, 32)
+}
+func Benchmark_SwissMap__GetMedKey___32(b *testing.B) {
+	benchmark_SwissMap__GetMedKey(b, 32)
+}
+func Benchmark_SwissMap__GetLrgKey___32(b *testing.B) {
+	benchmark_SwissMap__GetLrgKey(b, 32)
+}
 
 func Benchmark_GoMap___GetSmallKey_64(b *testing.B) {
 	benchmark_GoMap___GetSmallKey(b, 64)
@@ -468,6 +871,15 @@ func Benchmark_GoMap____GetLrgKey__64(b *testing.B) {
 func Benchmark_HashMap__GetLrgKey__64(b *testing.B) {
 	benchmark_HashMap__GetLrgKey(b, 64)
 }
+func Benchmark_SwissMap_GetSmallKey__64(b *testing.B) {
+	benchmark_SwissMap_GetSmallKey(b, 64)
+}
+func Benchmark_SwissMap__GetMedKey__64(b *testing.B) {
+	benchmark_SwissMap__GetMedKey(b, 64)
+}
+func Benchmark_SwissMap__GetLrgKey__64(b *testing.B) {
+	benchmark_SwissMap__GetLrgKey(b, 64)
+}
 
 func Benchmark_GoMap___GetSmallKey_128(b *testing.B) {
 	benchmark_GoMap___GetSmallKey(b, 128)
@@ -487,6 +899,15 @@ func Benchmark_GoMap____GetLrgKey__128(b *testing.B) {
 func Benchmark_HashMap__GetLrgKey__128(b *testing.B) {
 	benchmark_HashMap__GetLrgKey(b, 128)
 }
+func Benchmark_SwissMap_GetSmallKey__128(b *testing.B) {
+	benchmark_SwissMap_GetSmallKey(b, 128)
+}
+func Benchmark_SwissMap__GetMedKey__128(b *testing.B) {
+	benchmark_SwissMap__GetMedKey(b, 128)
+}
+func Benchmark_SwissMap__GetLrgKey__128(b *testing.B) {
+	benchmark_SwissMap__GetLrgKey(b, 128)
+}
 
 func Benchmark_GoMap___GetSmallKey__256(b *testing.B) {
 	benchmark_GoMap___GetSmallKey(b, 256)
@@ -506,6 +927,15 @@ func Benchmark_GoMap____GetLrgKey___256(b *testing.B) {
 func Benchmark_HashMap__GetLrgKey___256(b *testing.B) {
 	benchmark_HashMap__GetLrgKey(b, 256)
 }
+func Benchmark_SwissMap_GetSmallKey___256(b *testing.B) {
+	benchmark_SwissMap_GetSmallKey(b, 256)
+}
+func Benchmark_SwissMap__GetMedKey___256(b *testing.B) {
+	benchmark_SwissMap__GetMedKey(b, 256)
+}
+func Benchmark_SwissMap__GetLrgKey___256(b *testing.B) {
+	benchmark_SwissMap__GetLrgKey(b, 256)
+}
 
 func Benchmark_GoMap___GetSmallKey_512(b *testing.B) {
 	benchmark_GoMap___GetSmallKey(b, 512)
@@ -525,6 +955,15 @@ func Benchmark_GoMap____GetLrgKey__512(b *testing.B) {
 func Benchmark_HashMap__GetLrgKey__512(b *testing.B) {
 	benchmark_HashMap__GetLrgKey(b, 512)
 }
+func Benchmark_SwissMap_GetSmallKey__512(b *testing.B) {
+	benchmark_SwissMap_GetSmallKey(b, 512)
+}
+func Benchmark_SwissMap__GetMedKey__512(b *testing.B) {
+	benchmark_SwissMap__GetMedKey(b, 512)
+}
+func Benchmark_SwissMap__GetLrgKey__512(b *testing.B) {
+	benchmark_SwissMap__GetLrgKey(b, 512)
+}
 
 func Benchmark_GoMap___GetSmallKey_1024(b *testing.B) {
 	benchmark_GoMap___GetSmallKey(b, 1024)
@@ -544,6 +983,15 @@ func Benchmark_GoMap____GetLrgKey__1024(b *testing.B) {
 func Benchmark_HashMap__GetLrgKey__1024(b *testing.B) {
 	benchmark_HashMap__GetLrgKey(b, 1024)
 }
+func Benchmark_SwissMap_GetSmallKey__1024(b *testing.B) {
+	benchmark_SwissMap_GetSmallKey(b, 1024)
+}
+func Benchmark_SwissMap__GetMedKey__1024(b *testing.B) {
+	benchmark_SwissMap__GetMedKey(b, 1024)
+}
+func Benchmark_SwissMap__GetLrgKey__1024(b *testing.B) {
+	benchmark_SwissMap__GetLrgKey(b, 1024)
+}
 
 func Benchmark_GoMap___GetSmallKey__2048(b *testing.B) {
 	benchmark_GoMap___GetSmallKey(b, 2048)
@@ -563,6 +1011,15 @@ func Benchmark_GoMap____GetLrgKey___2048(b *testing.B) {
 func Benchmark_HashMap__GetLrgKey___2048(b *testing.B) {
 	benchmark_HashMap__GetLrgKey(b, 2048)
 }
+func Benchmark_SwissMap_GetSmallKey___2048(b *testing.B) {
+	benchmark_SwissMap_GetSmallKey(b, 2048)
+}
+func Benchmark_SwissMap__GetMedKey___2048(b *testing.B) {
+	benchmark_SwissMap__GetMedKey(b, 2048)
+}
+func Benchmark_SwissMap__GetLrgKey___2048(b *testing.B) {
+	benchmark_SwissMap__GetLrgKey(b, 2048)
+}
 
 func Benchmark_GoMap___GetSmallKey_4096(b *testing.B) {
 	benchmark_GoMap___GetSmallKey(b, 4096)
@@ -582,6 +1039,15 @@ func Benchmark_GoMap____GetLrgKey__4096(b *testing.B) {
 func Benchmark_HashMap__GetLrgKey__4096(b *testing.B) {
 	benchmark_HashMap__GetLrgKey(b, 4096)
 }
+func Benchmark_SwissMap_GetSmallKey__4096(b *testing.B) {
+	benchmark_SwissMap_GetSmallKey(b, 4096)
+}
+func Benchmark_SwissMap__GetMedKey__4096(b *testing.B) {
+	benchmark_SwissMap__GetMedKey(b, 4096)
+}
+func Benchmark_SwissMap__GetLrgKey__4096(b *testing.B) {
+	benchmark_SwissMap__GetLrgKey(b, 4096)
+}
 
 func Benchmark_GoMap___GetSmallKey__8192(b *testing.B) {
 	benchmark_GoMap___GetSmallKey(b, 8192)
@@ -601,6 +1067,15 @@ func Benchmark_GoMap____GetLrgKey___8192(b *testing.B) {
 func Benchmark_HashMap__GetLrgKey___8192(b *testing.B) {
 	benchmark_HashMap__GetLrgKey(b, 8192)
 }
+func Benchmark_SwissMap_GetSmallKey___8192(b *testing.B) {
+	benchmark_SwissMap_GetSmallKey(b, 8192)
+}
+func Benchmark_SwissMap__GetMedKey___8192(b *testing.B) {
+	benchmark_SwissMap__GetMedKey(b, 8192)
+}
+func Benchmark_SwissMap__GetLrgKey___8192(b *testing.B) {
+	benchmark_SwissMap__GetLrgKey(b, 8192)
+}
 
 func Benchmark_GoMap__________Set(b *testing.B) {
 	b.StopTimer()
@@ -642,6 +1117,25 @@ func Benchmark_HashMap________Set(b *testing.B) {
 	}
 }
 
+func Benchmark_SwissMap_______Set(b *testing.B) {
+	b.StopTimer()
+	m := NewSwissMap()
+	size := 10000
+	keys := make([][]byte, size)
+	for i := 0; i < len(keys); i++ {
+		keys[i] = []byte(fmt.Sprintf("foo.%d", i))
+		m.Set(keys[i], bar)
+	}
+	b.StartTimer()
+
+	Grp := b.N / size
+	for g := 0; g < Grp; g++ {
+		for i := 0; i < size; i++ {
+			m.Set(keys[i], bar)
+		}
+	}
+}
+
 func Benchmark_HashMapWithBktsSet(b *testing.B) {
 	b.StopTimer()
 	m, _ := NewHashMapWithBkts(16384)
@@ -662,6 +1156,100 @@ func Benchmark_HashMapWithBktsSet(b *testing.B) {
 	}
 }
 
+// boxed is bar already converted to an interface, so that the
+// benchmarks below do not count the allocation of boxing it.
+var boxed interface{} = bar
+
+// Churn benchmarks remove the oldest key and insert a new one, keeping
+// the map at a steady size, which is where pooled entries pay off.
+func benchmarkChurn(b *testing.B, size int, set func([]byte), remove func([]byte)) {
+	keys := make([][]byte, size<<1)
+	for i := 0; i < len(keys); i++ {
+		keys[i] = []byte(fmt.Sprintf("foo.%d", i))
+	}
+	for i := 0; i < size; i++ {
+		set(keys[i])
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		remove(keys[i%len(keys)])
+		set(keys[(i+size)%len(keys)])
+	}
+}
+
+func Benchmark_GoMap________Churn(b *testing.B) {
+	m := make(map[string][]byte)
+	benchmarkChurn(b, 10000,
+		func(k []byte) { m[string(k)] = bar },
+		func(k []byte) { delete(m, string(k)) })
+}
+
+func Benchmark_HashMap______Churn(b *testing.B) {
+	m := NewHashMap()
+	benchmarkChurn(b, 10000,
+		func(k []byte) { m.Set(k, boxed) },
+		func(k []byte) { m.Remove(k) })
+}
+
+func Benchmark_HashMap_GrowShrink(b *testing.B) {
+	m := NewHashMap()
+	keys := make([][]byte, 1024)
+	for i := 0; i < len(keys); i++ {
+		keys[i] = []byte(fmt.Sprintf("foo.%d", i))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	// Every iteration grows the map to 1024 entries and shrinks it back.
+	for i := 0; i < b.N; i++ {
+		for _, k := range keys {
+			m.Set(k, boxed)
+		}
+		for _, k := range keys {
+			m.Remove(k)
+		}
+	}
+}
+
+// benchmarkSetLatency inserts 1<<20 keys into a new map per iteration
+// and reports percentiles of the latency of a single Set.
+func benchmarkSetLatency(b *testing.B, opts ...Option) {
+	const n = 1 << 20
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("foo.bar.%d", i))
+	}
+	lat := make([]time.Duration, 0, n)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m := NewHashMap(opts...)
+		for _, k := range keys {
+			start := time.Now()
+			m.Set(k, boxed)
+			lat = append(lat, time.Since(start))
+		}
+	}
+	b.StopTimer()
+	sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+	pct := func(p float64) float64 {
+		return float64(lat[int(p*float64(len(lat)-1))])
+	}
+	b.ReportMetric(pct(0.5), "p50-ns")
+	b.ReportMetric(pct(0.99), "p99-ns")
+	b.ReportMetric(pct(0.9999), "p99.99-ns")
+	b.ReportMetric(pct(1), "max-ns")
+}
+
+func Benchmark_HashMap_SetLatency_Resize(b *testing.B) {
+	benchmarkSetLatency(b)
+}
+
+func Benchmark_HashMap_SetLatency_Rehash(b *testing.B) {
+	benchmarkSetLatency(b, WithIncrementalRehash(0))
+}
+
 var (
 	b1 = []byte("1234567890qwertyuiopasdfghjkl;zxcvbnm,./")
 	b2 = []byte("1234567890qwertyuiopasdfghjkl;zxcvbnm,.?")
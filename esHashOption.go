@@ -0,0 +1,291 @@
+// Options for the construction of HashMap and HashCache.
+package esMap
+
+import (
+	"errors"
+	"math/bits"
+	"math/rand"
+
+	"github.com/yireyun/go-map/hash"
+)
+
+// Option configures a HashMap when it is created.
+type Option func(*HashMap)
+
+// WithKeyCopy makes the HashMap own its keys. Set copies every new key
+// into slab memory managed by the map instead of keeping a reference
+// to the caller's slice, so callers are free to reuse their buffers
+// after Set returns. Once removed keys take most of the slab, the
+// map copies the rest to new memory and lets the old go.
+func WithKeyCopy() Option {
+	return func(h *HashMap) {
+		h.keys = new(keySlab)
+	}
+}
+
+// WithEntryTidy makes the HashMap release the memory of its free
+// entries once they make up freeRatio of all entries, keeping at
+// least minRetain entries allocated. See EntryArea.SetTidy.
+func WithEntryTidy(minRetain int, freeRatio float64) Option {
+	return func(h *HashMap) {
+		h.area.SetTidy(minRetain, freeRatio)
+	}
+}
+
+// _RSTEP is the default number of buckets moved per operation
+// while rehashing incrementally.
+const _RSTEP = 4
+
+// WithIncrementalRehash makes the HashMap resize incrementally. Rather
+// than moving every entry at once inside the Set or Remove that grows
+// or shrinks the map, old and new buckets coexist and every Set, Get
+// and Remove moves step buckets, so no single operation pays for the
+// whole resize. A step of 0 or less uses the default.
+func WithIncrementalRehash(step int) Option {
+	return func(h *HashMap) {
+		if step <= 0 {
+			step = _RSTEP
+		}
+		h.rstep = step
+	}
+}
+
+// _DOSCHAIN is the default chain length at which a HashMap assumes
+// its keys are crafted to collide. With a decent hash and the default
+// load factors, chains get nowhere near it.
+const _DOSCHAIN = 64
+
+// WithSeed makes the HashMap hash with DefaultSeededHash and seed. It
+// sets the 32 bit Hash, for 64 bit hashes seed the Hasher given to
+// WithHasher64.
+func WithSeed(seed uint32) Option {
+	return func(h *HashMap) {
+		h.Hash = hash.Seeded(DefaultSeededHash, seed)
+	}
+}
+
+// WithRandomSeed makes the HashMap hash with DefaultSeededHash and a
+// random seed of its own, so the HashMaps of a process do not share
+// their collisions. It does not make the hash keyed, keys crafted
+// against the structure of the hash still collide, see WithMapHash.
+func WithRandomSeed() Option {
+	return WithSeed(rand.Uint32())
+}
+
+// WithMapHash makes the HashMap hash with hash/maphash and a random
+// seed of its own. It is slower than the default hash, but keyed, so
+// use it where keys come from untrusted sources. With 64 bit hashes
+// set before, it replaces Hash64.
+func WithMapHash() Option {
+	return func(h *HashMap) {
+		mh := hash.NewMapHasher()
+		h.Hash = mh.Sum32
+		if h.Hash64 != nil {
+			h.Hash64 = mh.Sum64
+		}
+		h.dosAt = 0
+	}
+}
+
+// WithHasher makes the HashMap hash with the 32 bit output of hs.
+// The HashMap keeps hs however long its chains grow, unless
+// WithCollisionThreshold comes after this option.
+func WithHasher(hs hash.Hasher) Option {
+	return func(h *HashMap) {
+		h.Hash = hs.Sum32
+		h.Hash64 = nil
+		h.dosAt = 0
+	}
+}
+
+// WithHasher64 makes the HashMap hash with the 64 bit output of hs.
+// Entries keep all 64 bits, so keys are compared only when nearly
+// certain to match, and the HashMap can grow to 2^32 buckets instead
+// of 2^31. It still holds fewer than 2^32 elements.
+// As with WithHasher, the HashMap keeps hs unless
+// WithCollisionThreshold comes after this option.
+func WithHasher64(hs hash.Hasher) Option {
+	return func(h *HashMap) {
+		h.Hash = hs.Sum32
+		h.Hash64 = hs.Sum64
+		h.dosAt = 0
+	}
+}
+
+// WithCollisionThreshold sets the chain length at which the HashMap
+// considers itself under a hash flooding attack, switches to a keyed
+// hash as WithMapHash and rehashes its entries, which changes their
+// Entry.hk. The default is 64 with the default hash, seeded or not,
+// and should be raised along with MaxLoadFactor. A hash given by
+// WithHasher or WithHasher64 is only replaced with this option after
+// them, and one set on the Hash field after creating the HashMap
+// never is. A threshold of 0 or less disables the switch.
+func WithCollisionThreshold(n int) Option {
+	return func(h *HashMap) {
+		if n < 0 {
+			n = 0
+		}
+		h.dosAt = n
+	}
+}
+
+// WithMaxEntries bounds the map to n entries: once full, setting a
+// new key first evicts an entry chosen by the eviction policy, see
+// WithPolicy. A ConcurrentHashMap bounds each of its shards to n.
+func WithMaxEntries(n int) Option {
+	return func(h *HashMap) {
+		if n < 0 {
+			n = 0
+		}
+		h.max = uint32(n)
+	}
+}
+
+// WithPolicy selects the eviction policy of a map bounded by
+// WithMaxEntries. The default is PolicyRandom. Any other policy
+// needs WithMaxEntries, creating a map without it panics.
+func WithPolicy(p Policy) Option {
+	return func(h *HashMap) {
+		h.policy = p
+	}
+}
+
+// WithSamples sets the number of entries sampled per eviction by
+// PolicySampledLRU and PolicySampledLFU. More samples approximate
+// the exact policy better but make evicting slower. The default is 5.
+// Like WithPolicy, it needs WithMaxEntries.
+func WithSamples(k int) Option {
+	return func(h *HashMap) {
+		h.samples = k
+	}
+}
+
+// WithOnEvict sets a callback for the elements evicted from the map,
+// to release the resources held by their values. It runs once the
+// element is removed and must not use the map.
+func WithOnEvict(fn func(key []byte, data interface{}, reason EvictReason)) Option {
+	return func(h *HashMap) {
+		h.onEvict = fn
+	}
+}
+
+// Options are the growth policy of a HashMap. The zero value of
+// every field selects the default.
+type Options struct {
+	// MaxLoadFactor is the number of elements per bucket above which
+	// the HashMap grows. The default is 1.
+	MaxLoadFactor float64
+	// MinLoadFactor is the number of elements per bucket below which
+	// the HashMap shrinks by half. The default is 0.25.
+	MinLoadFactor float64
+	// GrowthFactor multiplies the number of buckets when growing. It
+	// must be a power of 2, the default is 2.
+	GrowthFactor int
+	// MinBuckets is the number of buckets the HashMap starts with and
+	// never shrinks below. It must be a power of 2, the default is 8.
+	MinBuckets int
+	// NoShrink disables shrinking altogether.
+	NoShrink bool
+}
+
+// DefaultOptions are the Options of a HashMap unless overridden.
+var DefaultOptions = Options{
+	MaxLoadFactor: 1,
+	MinLoadFactor: 0.25,
+	GrowthFactor:  2,
+	MinBuckets:    _BSZ,
+}
+
+// withDefaults returns o with its zero fields set to the defaults.
+func (o Options) withDefaults() Options {
+	if o.MaxLoadFactor == 0 {
+		o.MaxLoadFactor = DefaultOptions.MaxLoadFactor
+	}
+	if o.MinLoadFactor == 0 {
+		o.MinLoadFactor = DefaultOptions.MinLoadFactor
+	}
+	if o.GrowthFactor == 0 {
+		o.GrowthFactor = DefaultOptions.GrowthFactor
+	}
+	if o.MinBuckets == 0 {
+		o.MinBuckets = DefaultOptions.MinBuckets
+	}
+	if o.NoShrink {
+		o.MinLoadFactor = 0
+	}
+	return o
+}
+
+// Validate reports whether the Options describe a usable policy.
+func (o Options) Validate() error {
+	o = o.withDefaults()
+	g, b := o.GrowthFactor, o.MinBuckets
+	switch {
+	case o.MaxLoadFactor < 0 || o.MinLoadFactor < 0:
+		return errors.New("Load factors must not be negative")
+	case g < 2 || g&(g-1) != 0:
+		return errors.New("Growth factor must be a power of 2")
+	case b < 1 || b&(b-1) != 0 || b > maxBktSize:
+		return errors.New("Minimum buckets must be power of 2")
+	case o.MinLoadFactor*float64(g) >= o.MaxLoadFactor:
+		// Right after growing, the load factor must not be
+		// low enough to shrink again.
+		return errors.New("Minimum load factor too close to maximum")
+	}
+	return nil
+}
+
+// WithOptions sets the growth policy of the HashMap. It panics if
+// o does not Validate.
+func WithOptions(o Options) Option {
+	if err := o.Validate(); err != nil {
+		panic(err)
+	}
+	o = o.withDefaults()
+	return func(h *HashMap) {
+		if len(h.bkts) < o.MinBuckets {
+			h.bkts = make([]*Entry, o.MinBuckets)
+			h.msk = uint64(o.MinBuckets - 1)
+		}
+		h.setOptions(o)
+	}
+}
+
+// NewHashMapWithOptions creates a new HashMap with the growth policy o
+// and further options opts.
+func NewHashMapWithOptions(o Options, opts ...Option) (*HashMap, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	return NewHashMap(append([]Option{WithOptions(o)}, opts...)...), nil
+}
+
+// setOptions applies the growth policy o with its defaults set.
+func (h *HashMap) setOptions(o Options) {
+	h.maxLoad = o.MaxLoadFactor
+	h.minLoad = o.MinLoadFactor
+	h.gshift = uint(bits.TrailingZeros(uint(o.GrowthFactor)))
+	h.minBkts = uint32(o.MinBuckets)
+	h.setThresholds()
+}
+
+// init sets up a HashMap over bkts and applies the options.
+// len(bkts) must be a power of 2.
+func (h *HashMap) init(bkts []*Entry, opts []Option) {
+	h.msk = uint64(len(bkts) - 1)
+	h.bkts = bkts
+	h.Hash = DefaultHash
+	h.rsz = true
+	h.area = newEntryArea(DefBlockSize)
+	h.dosAt = _DOSCHAIN
+	h.setOptions(DefaultOptions)
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.dosHash = h.hashPC()
+	if h.max > 0 {
+		h.ev = h.policy.evictor(h)
+	} else if h.policy != PolicyRandom || h.samples != 0 {
+		panic("WithPolicy and WithSamples need WithMaxEntries")
+	}
+}
@@ -0,0 +1,415 @@
+package esMap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+	"testing"
+
+	"github.com/yireyun/go-map/hash"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	bad := []Options{
+		{MaxLoadFactor: -1},
+		{GrowthFactor: 3},
+		{GrowthFactor: 1},
+		{MinBuckets: 12},
+		{MaxLoadFactor: 1, MinLoadFactor: 0.5},
+	}
+	for _, o := range bad {
+		if o.Validate() == nil {
+			t.Fatalf("Options %+v should have failed\n", o)
+		}
+		if _, err := NewHashMapWithOptions(o); err == nil {
+			t.Fatalf("NewHashMapWithOptions(%+v) should have failed\n", o)
+		}
+	}
+	good := []Options{
+		{},
+		DefaultOptions,
+		{MaxLoadFactor: 4, GrowthFactor: 4},
+		{MaxLoadFactor: 0.5, MinLoadFactor: 0.1, MinBuckets: 1},
+		{MinLoadFactor: 0.9, MaxLoadFactor: 1, NoShrink: true},
+	}
+	for _, o := range good {
+		if err := o.Validate(); err != nil {
+			t.Fatalf("Options %+v should have succeeded: %v\n", o, err)
+		}
+	}
+}
+
+func TestOptionsLoadFactor(t *testing.T) {
+	h, _ := NewHashMapWithOptions(Options{MaxLoadFactor: 4, GrowthFactor: 4, MinBuckets: 16})
+	if len(h.bkts) != 16 {
+		t.Fatalf("Initial bucket size is wrong: %d vs 16\n", len(h.bkts))
+	}
+	keys := parallelKeys(65)
+	for _, k := range keys[:64] {
+		h.Set(k, nil)
+	}
+	if len(h.bkts) != 16 {
+		t.Fatalf("Should not grow at load factor 4: %d vs 16\n", len(h.bkts))
+	}
+	h.Set(keys[64], nil)
+	if len(h.bkts) != 64 {
+		t.Fatalf("Should grow by 4: %d vs 64\n", len(h.bkts))
+	}
+	for _, k := range keys {
+		h.Remove(k)
+	}
+	if len(h.bkts) != 16 {
+		t.Fatalf("Should not shrink below MinBuckets: %d vs 16\n", len(h.bkts))
+	}
+}
+
+func TestOptionsNoShrink(t *testing.T) {
+	h := NewHashMap(WithOptions(Options{NoShrink: true}))
+	keys := parallelKeys(INS)
+	for _, k := range keys {
+		h.Set(k, nil)
+	}
+	for _, k := range keys {
+		h.Remove(k)
+	}
+	if len(h.bkts) != EXP {
+		t.Fatalf("Should not shrink: %d vs %d\n", len(h.bkts), EXP)
+	}
+}
+
+func TestOptionsPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("WithOptions should panic on invalid Options\n")
+		}
+	}()
+	WithOptions(Options{GrowthFactor: 3})
+}
+
+func TestReserve(t *testing.T) {
+	h := NewHashMap(WithIncrementalRehash(0))
+	h.Set(foo, 1)
+	h.Reserve(1000)
+	if len(h.bkts) != 1024 || h.obkts != nil {
+		t.Fatalf("Reserve should resize at once to 1024: %d\n", len(h.bkts))
+	}
+	keys := parallelKeys(1000)
+	for _, k := range keys {
+		h.Set(k, nil)
+	}
+	if len(h.bkts) != 1024 {
+		t.Fatalf("Reserved map should not grow: %d\n", len(h.bkts))
+	}
+	if v := h.Get(foo); v == nil || v.(int) != 1 {
+		t.Fatalf("Lost entry over Reserve: %v\n", v)
+	}
+	// Reserving less than we have is a no-op
+	h.Reserve(10)
+	if len(h.bkts) != 1024 {
+		t.Fatalf("Reserve should not shrink: %d\n", len(h.bkts))
+	}
+	h2 := NewHashMap(WithOptions(Options{MaxLoadFactor: 2}))
+	h2.Reserve(1000)
+	if len(h2.bkts) != 512 {
+		t.Fatalf("Reserve should honour the load factor: %d vs 512\n", len(h2.bkts))
+	}
+}
+
+func TestClear(t *testing.T) {
+	h := NewHashMap(WithIncrementalRehash(1))
+	keys := parallelKeys(INS)
+	for _, k := range keys {
+		h.Set(k, nil)
+	}
+	bkts := len(h.bkts)
+	h.Clear()
+	if h.Count() != 0 || len(h.AllKeys()) != 0 {
+		t.Fatalf("Clear left %d entries\n", h.Count())
+	}
+	if len(h.bkts) != bkts {
+		t.Fatalf("Clear should keep the buckets: %d vs %d\n", len(h.bkts), bkts)
+	}
+	if h.area.freeCnt != h.area.totalCnt {
+		t.Fatalf("Clear should return the entries: %d free of %d\n", h.area.freeCnt, h.area.totalCnt)
+	}
+	for i, k := range keys {
+		h.Set(k, i)
+	}
+	for i, k := range keys {
+		if v := h.Get(k); v == nil || v.(int) != i {
+			t.Fatalf("Did not match properly after Clear, %v vs %d\n", v, i)
+		}
+	}
+	c := NewConcurrentHashMap()
+	c.Reserve(1 << 16)
+	for _, k := range keys {
+		c.Set(k, nil)
+	}
+	c.Clear()
+	if c.Count() != 0 {
+		t.Fatalf("Clear left %d entries\n", c.Count())
+	}
+}
+
+func TestSeededHash(t *testing.T) {
+	h1, h2 := NewHashMap(WithRandomSeed()), NewHashMap(WithRandomSeed())
+	h3, h4 := NewHashMap(WithSeed(7)), NewHashMap(WithSeed(7))
+	if h1.Hash(foo) == h2.Hash(foo) && h1.Hash(bar) == h2.Hash(bar) {
+		t.Fatalf("Random seeds should differ between maps\n")
+	}
+	if h3.Hash(foo) != h4.Hash(foo) {
+		t.Fatalf("Equal seeds should hash alike\n")
+	}
+	for _, h := range []*HashMap{h1, h3, NewHashMap(WithMapHash())} {
+		keys := make([][]byte, 1000)
+		for i := range keys {
+			keys[i] = []byte(fmt.Sprintf("foo.%d", i))
+			h.Set(keys[i], i)
+		}
+		for i, k := range keys {
+			if v := h.Get(k); v == nil || v.(int) != i {
+				t.Fatalf("Did not match properly with seeded hash, %v vs %d\n", v, i)
+			}
+		}
+	}
+}
+
+// permutations returns up to n distinct permutations of s.
+func permutations(s string, n int) [][]byte {
+	var keys [][]byte
+	var perm func(b []byte, i int)
+	perm = func(b []byte, i int) {
+		if len(keys) == n {
+			return
+		}
+		if i == len(b) {
+			keys = append(keys, append([]byte{}, b...))
+			return
+		}
+		for j := i; j < len(b); j++ {
+			b[i], b[j] = b[j], b[i]
+			perm(b, i+1)
+			b[i], b[j] = b[j], b[i]
+		}
+	}
+	perm([]byte(s), 0)
+	return keys
+}
+
+// wukehongCollisions returns n keys of 8 bytes which collide under
+// Wukehong whatever its seed: a round only mixes rotl(k1, 5) ^ k2 of
+// its two words into the hash, and the keys all share it.
+func wukehongCollisions(n int) [][]byte {
+	keys := make([][]byte, n)
+	for i := range keys {
+		k1 := uint32(i)
+		keys[i] = make([]byte, 8)
+		binary.LittleEndian.PutUint32(keys[i], k1)
+		binary.LittleEndian.PutUint32(keys[i][4:], bits.RotateLeft32(k1, 5)^0x5eed)
+	}
+	return keys
+}
+
+func TestHashFlooding(t *testing.T) {
+	keys := wukehongCollisions(4096)
+	for _, opts := range [][]Option{nil, {WithRandomSeed()}, {WithIncrementalRehash(1)}} {
+		h := NewHashMap(opts...)
+		for _, k := range keys {
+			if h.Hash(k) != h.Hash(keys[0]) {
+				t.Fatalf("Crafted keys should collide under the default hash\n")
+			}
+		}
+		for i, k := range keys {
+			h.Set(k, i)
+			// Rekeying may happen while rehashing incrementally.
+			if v, ok := h.Lookup(keys[i/2]); !ok || v.(int) != i/2 {
+				t.Fatalf("Lookup failed while rekeying, %v, %v vs %d\n", v, ok, i/2)
+			}
+		}
+		for i, k := range keys {
+			if v := h.Get(k); v == nil || v.(int) != i {
+				t.Fatalf("Did not match properly after rekeying, %v vs %d\n", v, i)
+			}
+		}
+		if s := h.Stats(); s.NumElements != uint32(len(keys)) || s.LongChain >= _DOSCHAIN {
+			t.Fatalf("HashMap did not switch to a keyed hash: %+v\n", s)
+		}
+		if h.Hash(keys[0]) == h.Hash(keys[1]) && h.Hash(keys[0]) == h.Hash(keys[2]) {
+			t.Fatalf("Hash was not replaced\n")
+		}
+	}
+
+	// A hash of the user's stays in use, whether given as an option
+	// or set on the field.
+	sum := func(b []byte) uint32 {
+		var hk uint32
+		for _, c := range b {
+			hk += uint32(c)
+		}
+		return hk
+	}
+	anagrams := permutations("abcdefgh", 256)
+	h1 := NewHashMap(WithHasher(hash.Func32(DefaultHash)))
+	h2 := NewHashMap()
+	h2.Hash = sum
+	h3 := NewHashMap(WithCollisionThreshold(0))
+	for i := range anagrams {
+		h1.Set(keys[i], i)
+		h2.Set(anagrams[i], i)
+		h3.Set(keys[i], i)
+	}
+	for _, h := range []*HashMap{h1, h2, h3} {
+		if s := h.Stats(); s.LongChain != 256 {
+			t.Fatalf("Expected a single chain of 256, got %d\n", s.LongChain)
+		}
+	}
+	if h2.Hash(anagrams[0]) != sum(anagrams[0]) {
+		t.Fatalf("Hash set on the field was replaced\n")
+	}
+
+	// Unless asked for after the option.
+	h := NewHashMap(WithHasher(hash.Func32(sum)), WithCollisionThreshold(_DOSCHAIN))
+	for i, k := range anagrams {
+		h.Set(k, i)
+	}
+	if s := h.Stats(); s.LongChain >= _DOSCHAIN {
+		t.Fatalf("HashMap did not switch to a keyed hash: %+v\n", s)
+	}
+}
+
+func TestConcurrentHashMapSeed(t *testing.T) {
+	c, _ := NewConcurrentHashMapWithShards(4, WithRandomSeed())
+	for i := range c.segs {
+		if c.segs[i].Hash(foo) != c.Hash(foo) || c.segs[i].dosAt != 0 {
+			t.Fatalf("Shard %d does not share the hash of the map\n", i)
+		}
+	}
+	keys := parallelKeys(1000)
+	for i, k := range keys {
+		c.Set(k, i)
+	}
+	for i, k := range keys {
+		if v := c.Get(k); v == nil || v.(int) != i {
+			t.Fatalf("Did not match properly with seeded hash, %v vs %d\n", v, i)
+		}
+	}
+}
+
+// collide32 hashes every key to the same 32 bits, but to distinct
+// 64 bits.
+type collide32 struct{ hash.XXH64 }
+
+func (collide32) Sum32([]byte) uint32 { return 0 }
+
+func TestHasher64(t *testing.T) {
+	h := NewHashMap(WithHasher64(collide32{}), WithCollisionThreshold(0))
+	keys := parallelKeys(1000)
+	for i, k := range keys {
+		h.Set(k, i)
+	}
+	for i, k := range keys {
+		if v := h.Get(k); v == nil || v.(int) != i {
+			t.Fatalf("Did not match properly with 64 bit hash, %v vs %d\n", v, i)
+		}
+	}
+	if s := h.Stats(); s.LongChain >= 16 {
+		t.Fatalf("64 bit hashes should spread the keys: %+v\n", s)
+	}
+	wide := false
+	for _, e := range h.bkts {
+		for ; e != nil; e = e.next {
+			wide = wide || e.hk > math.MaxUint32
+		}
+	}
+	if !wide {
+		t.Fatalf("Entries should keep 64 bit hashes\n")
+	}
+	if h.maxBkts() <= maxBktSize {
+		t.Fatalf("64 bit hashes should allow more than %d buckets\n", maxBktSize)
+	}
+	for _, k := range keys {
+		h.Remove(k)
+	}
+	if h.Count() != 0 {
+		t.Fatalf("Wrong number of entries: %d vs 0\n", h.Count())
+	}
+
+	// Back to 32 bits, everything collides.
+	h = NewHashMap(WithHasher64(collide32{}), WithHasher(collide32{}), WithCollisionThreshold(0))
+	for _, k := range keys[:100] {
+		h.Set(k, nil)
+	}
+	if s := h.Stats(); s.LongChain != 100 || h.maxBkts() != maxBktSize {
+		t.Fatalf("WithHasher should select 32 bit hashes: %+v\n", s)
+	}
+}
+
+func TestHasher64Flooding(t *testing.T) {
+	h := NewHashMap(WithHasher64(hash.Func32(func([]byte) uint32 { return 0 })),
+		WithCollisionThreshold(_DOSCHAIN))
+	keys := parallelKeys(1000)
+	for i, k := range keys {
+		h.Set(k, i)
+	}
+	if h.Hash64 == nil {
+		t.Fatalf("HashMap should stay with 64 bit hashes\n")
+	}
+	for i, k := range keys {
+		if v := h.Get(k); v == nil || v.(int) != i {
+			t.Fatalf("Did not match properly after rekeying, %v vs %d\n", v, i)
+		}
+	}
+	if s := h.Stats(); s.LongChain >= _DOSCHAIN {
+		t.Fatalf("HashMap did not switch to a keyed hash: %+v\n", s)
+	}
+}
+
+func TestConcurrentHashMapHasher64(t *testing.T) {
+	c, _ := NewConcurrentHashMapWithShards(8, WithHasher64(collide32{}))
+	keys := parallelKeys(1000)
+	for i, k := range keys {
+		c.Set(k, i)
+	}
+	for i, k := range keys {
+		if v := c.Get(k); v == nil || v.(int) != i {
+			t.Fatalf("Did not match properly with 64 bit hash, %v vs %d\n", v, i)
+		}
+	}
+	// The shards are picked by the high bits of the 64 bit hash.
+	for i := range c.segs {
+		if n := c.segs[i].Count(); n == 0 || n == uint32(len(keys)) {
+			t.Fatalf("Shard %d holds %d of %d keys\n", i, n, len(keys))
+		}
+	}
+}
+
+func ExampleOptions() {
+	h, err := NewHashMapWithOptions(Options{MaxLoadFactor: 4, NoShrink: true})
+	if err != nil {
+		panic(err)
+	}
+	h.Reserve(1 << 10)
+	fmt.Println(h.Stats().NumBuckets)
+	// Output: 256
+}
+
+func benchmarkHasherGet(b *testing.B, opt Option, size int) {
+	b.StopTimer()
+	m := NewHashMap(opt)
+	keys := parallelKeys(size)
+	for _, k := range keys {
+		m.Set(k, bar)
+	}
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		_ = m.Get(keys[i%size])
+	}
+}
+
+func Benchmark_HashMap_GetXXH32__65536(b *testing.B) {
+	benchmarkHasherGet(b, WithHasher(hash.XXH64(0)), 1<<16)
+}
+func Benchmark_HashMap_GetXXH64__65536(b *testing.B) {
+	benchmarkHasherGet(b, WithHasher64(hash.XXH64(0)), 1<<16)
+}
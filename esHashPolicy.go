@@ -0,0 +1,375 @@
+// Scan resistant eviction policies: ARC, 2Q, S3-FIFO and SIEVE.
+package esMap
+
+// ghostList remembers the hashes of keys evicted recently, most
+// recent at the front, up to max of them. Its nodes come from a
+// fixed pool, linked by index.
+type ghostList struct {
+	nodes       []ghost
+	index       map[uint64]int32
+	front, back int32
+	free        int32
+	n           int
+}
+
+type ghost struct {
+	hk         uint64
+	prev, next int32
+}
+
+// _GNIL is the index of no node.
+const _GNIL = -1
+
+func newGhostList(max int) ghostList {
+	g := ghostList{
+		nodes: make([]ghost, max),
+		index: make(map[uint64]int32, max),
+		front: _GNIL,
+		back:  _GNIL,
+		free:  _GNIL,
+	}
+	for i := range g.nodes {
+		g.nodes[i].next = g.free
+		g.free = int32(i)
+	}
+	return g
+}
+
+// add remembers hk at the front, forgetting the oldest hash if full.
+func (g *ghostList) add(hk uint64) {
+	if len(g.nodes) == 0 {
+		return
+	}
+	g.remove(hk)
+	if g.free == _GNIL {
+		g.popBack()
+	}
+	i := g.free
+	n := &g.nodes[i]
+	g.free = n.next
+	n.hk, n.prev, n.next = hk, _GNIL, g.front
+	if g.front != _GNIL {
+		g.nodes[g.front].prev = i
+	} else {
+		g.back = i
+	}
+	g.front = i
+	g.index[hk] = i
+	g.n++
+}
+
+// remove forgets hk and reports whether it was remembered.
+func (g *ghostList) remove(hk uint64) bool {
+	i, ok := g.index[hk]
+	if !ok {
+		return false
+	}
+	n := &g.nodes[i]
+	if n.prev != _GNIL {
+		g.nodes[n.prev].next = n.next
+	} else {
+		g.front = n.next
+	}
+	if n.next != _GNIL {
+		g.nodes[n.next].prev = n.prev
+	} else {
+		g.back = n.prev
+	}
+	n.next = g.free
+	g.free = i
+	delete(g.index, hk)
+	g.n--
+	return true
+}
+
+// contains reports whether hk is remembered.
+func (g *ghostList) contains(hk uint64) bool {
+	_, ok := g.index[hk]
+	return ok
+}
+
+// popBack forgets the oldest hash.
+func (g *ghostList) popBack() {
+	if g.back != _GNIL {
+		g.remove(g.nodes[g.back].hk)
+	}
+}
+
+// Lists of ARC, held by Entry.stamp.
+const (
+	_ARCT1 = iota
+	_ARCT2
+)
+
+// arcEvictor is PolicyARC, after Megiddo and Modha. T1 holds the
+// entries used once and T2 those used again, B1 and B2 the hashes of
+// the keys evicted from them. A new key found in B1 means T1 should
+// have been larger, so the target size p of T1 grows, and a key
+// found in B2 shrinks it. The victims come from T1 while it is above
+// p and from T2 otherwise.
+type arcEvictor struct {
+	t1, t2 entryList
+	b1, b2 ghostList
+	c, p   int
+	// evicting is the victim chosen last, ghost tells which ghost
+	// list remembers it once removed, if any.
+	evicting *Entry
+	ghost    *ghostList
+}
+
+func newARC(max int) *arcEvictor {
+	return &arcEvictor{b1: newGhostList(max), b2: newGhostList(max), c: max}
+}
+
+func (a *arcEvictor) added(e *Entry) {
+	switch {
+	case a.b1.remove(e.hk):
+		// T1 was too small to keep this key.
+		a.p += max(a.b2.n/max(a.b1.n+1, 1), 1)
+		a.p = min(a.p, a.c)
+	case a.b2.remove(e.hk):
+		// T2 was too small to keep this key.
+		a.p -= max(a.b1.n/max(a.b2.n+1, 1), 1)
+		a.p = max(a.p, 0)
+	default:
+		// A new key, trim the ghosts to keep T1 and B1 within the
+		// capacity, and all lists within twice the capacity.
+		if a.t1.n+a.b1.n >= a.c {
+			a.b1.popBack()
+		} else if a.t1.n+a.t2.n+a.b1.n+a.b2.n >= 2*a.c {
+			a.b2.popBack()
+		}
+		e.stamp = _ARCT1
+		a.t1.pushFront(e)
+		return
+	}
+	e.stamp = _ARCT2
+	a.t2.pushFront(e)
+}
+
+func (a *arcEvictor) accessed(e *Entry) {
+	if e.stamp == _ARCT1 {
+		a.t1.remove(e)
+		e.stamp = _ARCT2
+		a.t2.pushFront(e)
+		return
+	}
+	a.t2.moveToFront(e)
+}
+
+func (a *arcEvictor) removed(e *Entry) {
+	if e.stamp == _ARCT1 {
+		a.t1.remove(e)
+	} else {
+		a.t2.remove(e)
+	}
+	if e == a.evicting {
+		if a.ghost != nil {
+			a.ghost.add(e.hk)
+		}
+		a.evicting, a.ghost = nil, nil
+	}
+}
+
+func (a *arcEvictor) victim(hk uint64) *Entry {
+	inB1, inB2 := a.b1.contains(hk), a.b2.contains(hk)
+	switch {
+	case !inB1 && !inB2 && a.t1.n >= a.c:
+		// T1 takes the whole cache, its victims are not worth
+		// remembering.
+		a.evicting, a.ghost = a.t1.back, nil
+	case a.t1.n > 0 && (a.t1.n > a.p || inB2 && a.t1.n == a.p) || a.t2.n == 0:
+		a.evicting, a.ghost = a.t1.back, &a.b1
+	default:
+		a.evicting, a.ghost = a.t2.back, &a.b2
+	}
+	return a.evicting
+}
+
+// Queues of 2Q, held by Entry.stamp.
+const (
+	_2QA1IN = iota
+	_2QAM
+)
+
+// twoQEvictor is Policy2Q, the full version of Johnson and Shasha.
+// New entries go to the FIFO queue A1in, holding a quarter of the
+// cache, and the hashes of the keys evicted from it to the ghost
+// list A1out, remembering half as many keys as the cache holds. Keys
+// found in A1out go to the main LRU Am. Uses in A1in do not count,
+// they are taken as correlated with the first one.
+type twoQEvictor struct {
+	a1in, am entryList
+	a1out    ghostList
+	kin      int
+	evicting *Entry
+}
+
+func new2Q(max int) *twoQEvictor {
+	return &twoQEvictor{a1out: newGhostList(max / 2), kin: max / 4}
+}
+
+func (q *twoQEvictor) added(e *Entry) {
+	if q.a1out.remove(e.hk) {
+		e.stamp = _2QAM
+		q.am.pushFront(e)
+		return
+	}
+	e.stamp = _2QA1IN
+	q.a1in.pushFront(e)
+}
+
+func (q *twoQEvictor) accessed(e *Entry) {
+	if e.stamp == _2QAM {
+		q.am.moveToFront(e)
+	}
+}
+
+func (q *twoQEvictor) removed(e *Entry) {
+	if e.stamp == _2QAM {
+		q.am.remove(e)
+		return
+	}
+	q.a1in.remove(e)
+	if e == q.evicting {
+		q.a1out.add(e.hk)
+		q.evicting = nil
+	}
+}
+
+func (q *twoQEvictor) victim(uint64) *Entry {
+	if q.a1in.n > q.kin || q.am.n == 0 {
+		q.evicting = q.a1in.back
+		return q.evicting
+	}
+	return q.am.back
+}
+
+// S3-FIFO keeps the queue of an entry in bit 2 of Entry.stamp and
+// its use count, up to 3, in bits 0 and 1.
+const (
+	_S3MAIN  = 4
+	_S3USES  = 3
+	_S3SMALL = 10 // percent of the cache in the small queue
+)
+
+// s3FIFOEvictor is PolicyS3FIFO, after Yang et al. New entries go to
+// the small FIFO queue S, or to the main FIFO queue M if their key is
+// in the ghost list G of keys evicted from S. Entries leaving S move
+// to M if used more than once, and entries leaving M go back to its
+// front with one use less as long as they have uses left. Accesses
+// only count uses, so hits do not touch the queues.
+type s3FIFOEvictor struct {
+	small, main entryList
+	ghosts      ghostList
+	smax        int
+	evicting    *Entry
+}
+
+func newS3FIFO(max int) *s3FIFOEvictor {
+	smax := max * _S3SMALL / 100
+	if smax < 1 {
+		smax = 1
+	}
+	return &s3FIFOEvictor{ghosts: newGhostList(max - smax), smax: smax}
+}
+
+func (s *s3FIFOEvictor) added(e *Entry) {
+	if s.ghosts.remove(e.hk) {
+		e.stamp = _S3MAIN
+		s.main.pushFront(e)
+		return
+	}
+	e.stamp = 0
+	s.small.pushFront(e)
+}
+
+func (s *s3FIFOEvictor) accessed(e *Entry) {
+	if e.stamp&_S3USES < _S3USES {
+		e.stamp++
+	}
+}
+
+func (s *s3FIFOEvictor) removed(e *Entry) {
+	if e.stamp&_S3MAIN != 0 {
+		s.main.remove(e)
+		return
+	}
+	s.small.remove(e)
+	if e == s.evicting {
+		s.ghosts.add(e.hk)
+		s.evicting = nil
+	}
+}
+
+func (s *s3FIFOEvictor) victim(uint64) *Entry {
+	for {
+		if s.small.n > s.smax || s.main.n == 0 {
+			e := s.small.back
+			if e == nil {
+				return nil
+			}
+			if e.stamp&_S3USES <= 1 {
+				s.evicting = e
+				return e
+			}
+			s.small.remove(e)
+			e.stamp = _S3MAIN
+			s.main.pushFront(e)
+			continue
+		}
+		e := s.main.back
+		if e.stamp&_S3USES == 0 {
+			return e
+		}
+		e.stamp--
+		s.main.moveToFront(e)
+	}
+}
+
+// _SIEVEVISITED is the visited bit of SIEVE in Entry.stamp.
+const _SIEVEVISITED = 1
+
+// sieveEvictor is PolicySIEVE, after Zhang et al. New entries go to
+// the front of the queue and accesses mark entries visited. The hand
+// moves from the back to the front, clearing the visited bits, and
+// the first entry not visited is the victim. The hand stays where it
+// is, so entries behind it that survived keep their place.
+type sieveEvictor struct {
+	entryList
+	hand *Entry
+}
+
+func (s *sieveEvictor) added(e *Entry) {
+	e.stamp = 0
+	s.pushFront(e)
+}
+
+func (s *sieveEvictor) accessed(e *Entry) {
+	e.stamp = _SIEVEVISITED
+}
+
+func (s *sieveEvictor) removed(e *Entry) {
+	if e == s.hand {
+		s.hand = e.lprev
+	}
+	s.remove(e)
+}
+
+func (s *sieveEvictor) victim(uint64) *Entry {
+	e := s.hand
+	for {
+		if e == nil {
+			e = s.back
+			if e == nil {
+				return nil
+			}
+		}
+		if e.stamp&_SIEVEVISITED == 0 {
+			s.hand = e
+			return e
+		}
+		e.stamp = 0
+		e = e.lprev
+	}
+}
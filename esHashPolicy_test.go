@@ -0,0 +1,323 @@
+package esMap
+
+import (
+	"math/rand"
+	"testing"
+)
+
+var allPolicies = []Policy{
+	PolicyRandom, PolicySampledLRU, PolicySampledLFU, PolicyLRU, PolicyLFU,
+	PolicyTinyLFU, PolicyARC, Policy2Q, PolicyS3FIFO, PolicySIEVE,
+}
+
+// policyLen returns the number of entries the lists of an evictor
+// hold, or -1 for an evictor without lists of its own here.
+func policyLen(ev evictor) int {
+	switch ev := ev.(type) {
+	case *arcEvictor:
+		return ev.t1.n + ev.t2.n
+	case *twoQEvictor:
+		return ev.a1in.n + ev.am.n
+	case *s3FIFOEvictor:
+		return ev.small.n + ev.main.n
+	case *sieveEvictor:
+		return ev.n
+	case *lruEvictor:
+		return ev.n
+	}
+	return -1
+}
+
+func TestPolicyInvariants(t *testing.T) {
+	const max = 64
+	for _, p := range allPolicies {
+		h := NewHashCache(WithMaxEntries(max), WithPolicy(p))
+		keys := parallelKeys(4 * max)
+		rnd := rand.New(rand.NewSource(1))
+		for i := 0; i < 100000; i++ {
+			k := keys[rnd.Intn(len(keys))]
+			switch op := rnd.Intn(100); {
+			case op < 40:
+				h.Set(k, i)
+			case op < 80:
+				h.Get(k)
+			case op < 90:
+				h.Remove(k)
+			case op < 95:
+				h.Update(k, func(old interface{}, exists bool) (interface{}, bool) {
+					return i, !exists
+				})
+			case op < 99:
+				h.RemoveRandom()
+			default:
+				h.Clear()
+			}
+			if h.Count() > max {
+				t.Fatalf("Policy %v: cache holds %d entries, max %d\n", p, h.Count(), max)
+			}
+			if n := policyLen(h.ev); n >= 0 && n != int(h.Count()) {
+				t.Fatalf("Policy %v: lists hold %d entries of %d\n", p, n, h.Count())
+			}
+		}
+		for _, g := range ghostLists(h.ev) {
+			if g.n != len(g.index) || g.n > len(g.nodes) {
+				t.Fatalf("Policy %v: ghost list holds %d hashes, %d indexed, max %d\n",
+					p, g.n, len(g.index), len(g.nodes))
+			}
+		}
+	}
+}
+
+// ghostLists returns the ghost lists of an evictor.
+func ghostLists(ev evictor) []*ghostList {
+	switch ev := ev.(type) {
+	case *arcEvictor:
+		return []*ghostList{&ev.b1, &ev.b2}
+	case *twoQEvictor:
+		return []*ghostList{&ev.a1out}
+	case *s3FIFOEvictor:
+		return []*ghostList{&ev.ghosts}
+	}
+	return nil
+}
+
+func TestGhostList(t *testing.T) {
+	g := newGhostList(3)
+	for hk := uint64(1); hk <= 4; hk++ {
+		g.add(hk)
+	}
+	if g.contains(1) || !g.contains(2) || !g.contains(4) || g.n != 3 {
+		t.Fatalf("Ghost list should forget the oldest hash, holds %d\n", g.n)
+	}
+	// Adding again makes a hash the most recent.
+	g.add(2)
+	g.popBack()
+	if !g.contains(2) || g.contains(3) || g.remove(3) || !g.remove(4) || g.n != 1 {
+		t.Fatalf("Ghost list holds %d hashes\n", g.n)
+	}
+	var empty ghostList
+	empty.add(1)
+	if empty.contains(1) {
+		t.Fatalf("Ghost list of no hashes remembered one\n")
+	}
+}
+
+func TestARC(t *testing.T) {
+	ev := newEvicted()
+	h := NewHashCache(WithMaxEntries(4), WithPolicy(PolicyARC), WithOnEvict(ev.onEvict))
+	keys := parallelKeys(8)
+	for _, k := range keys[:4] {
+		h.Set(k, nil)
+	}
+	// foo.0 and foo.1 move to T2, the target size of T1 is 0.
+	h.Get(keys[0])
+	h.Get(keys[1])
+	for i, exp := range []string{"foo.2", "foo.3", "foo.0"} {
+		// foo.2 comes back from B1 into T2 and grows T1.
+		h.Set([][]byte{keys[4], keys[2], keys[5]}[i], nil)
+		if !ev.has(exp) || len(ev.keys) != i+1 {
+			t.Fatalf("Expected %s to be evicted, got %v\n", exp, ev.keys)
+		}
+	}
+	a := h.ev.(*arcEvictor)
+	if a.p != 1 || !a.b2.contains(h.hash(keys[0])) {
+		t.Fatalf("Target size of T1 is %d\n", a.p)
+	}
+	// foo.0 comes back from B2, which shrinks T1 again.
+	h.Set(keys[0], nil)
+	if !ev.has("foo.4") || a.p != 0 || a.t2.n != 3 || a.t1.n != 1 {
+		t.Fatalf("Expected foo.4 to be evicted, got %v, target %d\n", ev.keys, a.p)
+	}
+}
+
+func Test2Q(t *testing.T) {
+	ev := newEvicted()
+	h := NewHashCache(WithMaxEntries(8), WithPolicy(Policy2Q), WithOnEvict(ev.onEvict))
+	keys := parallelKeys(12)
+	for _, k := range keys[:8] {
+		h.Set(k, nil)
+		h.Get(k)
+	}
+	// Uses in A1in do not count, the oldest goes first.
+	h.Set(keys[8], nil)
+	h.Set(keys[0], nil)
+	q := h.ev.(*twoQEvictor)
+	if !ev.has("foo.0") || !ev.has("foo.1") || q.am.n != 1 || q.am.front != h.lookup(h.hash(keys[0]), keys[0]) {
+		t.Fatalf("Expected foo.0 back in Am, evicted %v\n", ev.keys)
+	}
+	// Am keeps its entries while A1in is over its share.
+	for _, k := range keys[9:] {
+		h.Set(k, nil)
+	}
+	if !h.Contains(keys[0]) || q.a1in.n != 7 {
+		t.Fatalf("Am lost foo.0, A1in holds %d\n", q.a1in.n)
+	}
+}
+
+func TestS3FIFO(t *testing.T) {
+	ev := newEvicted()
+	h := NewHashCache(WithMaxEntries(10), WithPolicy(PolicyS3FIFO), WithOnEvict(ev.onEvict))
+	keys := parallelKeys(12)
+	for _, k := range keys[:10] {
+		h.Set(k, nil)
+	}
+	// foo.0 was used twice and moves to M, foo.1 only once.
+	h.Get(keys[0])
+	h.Get(keys[0])
+	h.Get(keys[1])
+	for i, exp := range []string{"foo.1", "foo.2"} {
+		h.Set(keys[10+i], nil)
+		if !ev.has(exp) || len(ev.keys) != i+1 {
+			t.Fatalf("Expected %s to be evicted, got %v\n", exp, ev.keys)
+		}
+	}
+	// foo.1 was evicted recently, so it goes straight to M.
+	h.Set(keys[1], nil)
+	s := h.ev.(*s3FIFOEvictor)
+	if !ev.has("foo.3") || s.main.n != 2 || s.ghosts.contains(h.hash(keys[1])) {
+		t.Fatalf("Expected foo.1 in M, evicted %v\n", ev.keys)
+	}
+}
+
+func TestSIEVE(t *testing.T) {
+	ev := newEvicted()
+	h := NewHashCache(WithMaxEntries(4), WithPolicy(PolicySIEVE), WithOnEvict(ev.onEvict))
+	keys := parallelKeys(8)
+	for _, k := range keys[:4] {
+		h.Set(k, nil)
+	}
+	// The hand spares foo.0 and foo.2, and then new entries.
+	h.Get(keys[0])
+	h.Get(keys[2])
+	for i, exp := range []string{"foo.1", "foo.3", "foo.4", "foo.5"} {
+		h.Set(keys[4+i], nil)
+		if !ev.has(exp) || len(ev.keys) != i+1 {
+			t.Fatalf("Expected %s to be evicted, got %v\n", exp, ev.keys)
+		}
+	}
+	if !h.Contains(keys[0]) || !h.Contains(keys[2]) {
+		t.Fatalf("Visited entries were evicted: %v\n", ev.keys)
+	}
+	// Removing the entry under the hand moves it on.
+	s := h.ev.(*sieveEvictor)
+	hand := string(s.hand.key)
+	h.Remove(s.hand.key)
+	h.Set(keys[1], nil)
+	h.Set(keys[3], nil)
+	if s.n != 4 || len(ev.keys) != 5 || ev.has(hand) {
+		t.Fatalf("Removing %s under the hand, evicted %v\n", hand, ev.keys)
+	}
+}
+
+func TestScanResistance(t *testing.T) {
+	lru := hitRatio(PolicyLRU, _CACHESIZE, scanTraceKeys)
+	for _, p := range []Policy{PolicyARC, Policy2Q, PolicyS3FIFO, PolicySIEVE} {
+		if r := hitRatio(p, _CACHESIZE, scanTraceKeys); r <= lru {
+			t.Fatalf("Policy %v hit ratio %.3f should beat LRU %.3f on scans\n", p, r, lru)
+		}
+	}
+}
+
+func TestRecordedPolicies(t *testing.T) {
+	random := hitRatio(PolicyRandom, _RECORDEDSIZE, nethttpTraceKeys)
+	lru := hitRatio(PolicyLRU, _RECORDEDSIZE, nethttpTraceKeys)
+	// Without scans to resist, they should do about as well as LRU
+	// on a recency heavy trace.
+	for _, p := range []Policy{PolicyARC, Policy2Q, PolicyS3FIFO, PolicySIEVE} {
+		if r := hitRatio(p, _RECORDEDSIZE, nethttpTraceKeys); r <= random || r < lru-0.01 {
+			t.Fatalf("Policy %v hit ratio %.3f on net/http vs LRU %.3f, random %.3f\n", p, r, lru, random)
+		}
+	}
+}
+
+func Benchmark_HashCache_Zipf_ARC(b *testing.B) {
+	benchmarkHitRatio(b, PolicyARC, zipfTraceKeys)
+}
+func Benchmark_HashCache_Zipf_2Q(b *testing.B) {
+	benchmarkHitRatio(b, Policy2Q, zipfTraceKeys)
+}
+func Benchmark_HashCache_Zipf_S3FIFO(b *testing.B) {
+	benchmarkHitRatio(b, PolicyS3FIFO, zipfTraceKeys)
+}
+func Benchmark_HashCache_Zipf_SIEVE(b *testing.B) {
+	benchmarkHitRatio(b, PolicySIEVE, zipfTraceKeys)
+}
+func Benchmark_HashCache_Scan_ARC(b *testing.B) {
+	benchmarkHitRatio(b, PolicyARC, scanTraceKeys)
+}
+func Benchmark_HashCache_Scan_2Q(b *testing.B) {
+	benchmarkHitRatio(b, Policy2Q, scanTraceKeys)
+}
+func Benchmark_HashCache_Scan_S3FIFO(b *testing.B) {
+	benchmarkHitRatio(b, PolicyS3FIFO, scanTraceKeys)
+}
+func Benchmark_HashCache_Scan_SIEVE(b *testing.B) {
+	benchmarkHitRatio(b, PolicySIEVE, scanTraceKeys)
+}
+func Benchmark_HashCache_NetHTTP_ARC(b *testing.B) {
+	benchmarkHitRatioSize(b, PolicyARC, _RECORDEDSIZE, nethttpTraceKeys)
+}
+func Benchmark_HashCache_NetHTTP_2Q(b *testing.B) {
+	benchmarkHitRatioSize(b, Policy2Q, _RECORDEDSIZE, nethttpTraceKeys)
+}
+func Benchmark_HashCache_NetHTTP_S3FIFO(b *testing.B) {
+	benchmarkHitRatioSize(b, PolicyS3FIFO, _RECORDEDSIZE, nethttpTraceKeys)
+}
+func Benchmark_HashCache_NetHTTP_SIEVE(b *testing.B) {
+	benchmarkHitRatioSize(b, PolicySIEVE, _RECORDEDSIZE, nethttpTraceKeys)
+}
+
+// benchmarkThroughput runs b.N requests of the Zipf trace through a
+// read-through cache with policy, the cost per operation of the
+// policy against PolicyRandom.
+func benchmarkThroughput(b *testing.B, policy Policy) {
+	h := NewHashCache(WithMaxEntries(_CACHESIZE), WithPolicy(policy))
+	// Warm up so every policy runs full.
+	for _, k := range zipfTraceKeys[:4*_CACHESIZE] {
+		if _, ok := h.Lookup(k); !ok {
+			h.Set(k, nil)
+		}
+	}
+	hits := 0
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		k := zipfTraceKeys[i&(_TRACELEN-1)]
+		if _, ok := h.Lookup(k); ok {
+			hits++
+		} else {
+			h.Set(k, nil)
+		}
+	}
+	b.ReportMetric(100*float64(hits)/float64(b.N), "hit%")
+}
+
+func Benchmark_HashCache_Throughput_Random(b *testing.B) {
+	benchmarkThroughput(b, PolicyRandom)
+}
+func Benchmark_HashCache_Throughput_SampledLRU(b *testing.B) {
+	benchmarkThroughput(b, PolicySampledLRU)
+}
+func Benchmark_HashCache_Throughput_SampledLFU(b *testing.B) {
+	benchmarkThroughput(b, PolicySampledLFU)
+}
+func Benchmark_HashCache_Throughput_LRU(b *testing.B) {
+	benchmarkThroughput(b, PolicyLRU)
+}
+func Benchmark_HashCache_Throughput_LFU(b *testing.B) {
+	benchmarkThroughput(b, PolicyLFU)
+}
+func Benchmark_HashCache_Throughput_TinyLFU(b *testing.B) {
+	benchmarkThroughput(b, PolicyTinyLFU)
+}
+func Benchmark_HashCache_Throughput_ARC(b *testing.B) {
+	benchmarkThroughput(b, PolicyARC)
+}
+func Benchmark_HashCache_Throughput_2Q(b *testing.B) {
+	benchmarkThroughput(b, Policy2Q)
+}
+func Benchmark_HashCache_Throughput_S3FIFO(b *testing.B) {
+	benchmarkThroughput(b, PolicyS3FIFO)
+}
+func Benchmark_HashCache_Throughput_SIEVE(b *testing.B) {
+	benchmarkThroughput(b, PolicySIEVE)
+}
@@ -0,0 +1,114 @@
+// Read-modify-write operations of HashMap. Each hashes the key once
+// and walks its chain once.
+package esMap
+
+// GetOrSet returns the item at key if present. Otherwise it sets
+// key to data and returns data. loaded reports whether the item
+// was present.
+func (h *HashMap) GetOrSet(key []byte, data interface{}) (actual interface{}, loaded bool) {
+	return h.getOrSet(h.hash(key), key, data)
+}
+
+// getOrSet is GetOrSet for a key already hashed to hk.
+func (h *HashMap) getOrSet(hk uint64, key []byte, data interface{}) (interface{}, bool) {
+	b, e, n := h.find(hk, key)
+	if *e != nil {
+		h.touch(*e)
+		return (*e).data, true
+	}
+	h.insert(b, n, hk, key, data)
+	return data, false
+}
+
+// SetIfAbsent sets key to data unless key is present. It reports
+// whether data was set.
+func (h *HashMap) SetIfAbsent(key []byte, data interface{}) bool {
+	_, loaded := h.getOrSet(h.hash(key), key, data)
+	return !loaded
+}
+
+// Swap sets key to data and returns the previous item, if any.
+// loaded reports whether the key was present.
+func (h *HashMap) Swap(key []byte, data interface{}) (previous interface{}, loaded bool) {
+	return h.swap(h.hash(key), key, data)
+}
+
+// swap is Swap for a key already hashed to hk.
+func (h *HashMap) swap(hk uint64, key []byte, data interface{}) (interface{}, bool) {
+	b, e, n := h.find(hk, key)
+	if *e != nil {
+		previous := (*e).data
+		(*e).data = data
+		h.touch(*e)
+		return previous, true
+	}
+	h.insert(b, n, hk, key, data)
+	return nil, false
+}
+
+// CompareAndSwap sets key to data if the item at key is equal to
+// old, and reports whether it did. Like sync.Map, it panics if the
+// item and old are of the same type which is not comparable.
+func (h *HashMap) CompareAndSwap(key []byte, old, data interface{}) bool {
+	return h.compareAndSwap(h.hash(key), key, old, data)
+}
+
+// compareAndSwap is CompareAndSwap for a key already hashed to hk.
+func (h *HashMap) compareAndSwap(hk uint64, key []byte, old, data interface{}) bool {
+	_, e, _ := h.find(hk, key)
+	if *e == nil || (*e).data != old {
+		return false
+	}
+	(*e).data = data
+	h.touch(*e)
+	return true
+}
+
+// CompareAndDelete removes key if the item at key is equal to old,
+// and reports whether it did. Like sync.Map, it panics if the item
+// and old are of the same type which is not comparable.
+func (h *HashMap) CompareAndDelete(key []byte, old interface{}) bool {
+	return h.compareAndDelete(h.hash(key), key, old)
+}
+
+// compareAndDelete is CompareAndDelete for a key already hashed to hk.
+func (h *HashMap) compareAndDelete(hk uint64, key []byte, old interface{}) bool {
+	_, e, _ := h.find(hk, key)
+	if *e == nil || (*e).data != old {
+		return false
+	}
+	h.unlink(e)
+	return true
+}
+
+// UpdateFunc computes the new item at a key from the old one, if
+// present. When keep is false the key is removed.
+type UpdateFunc func(old interface{}, exists bool) (data interface{}, keep bool)
+
+// Update sets key to the item returned by fn, or removes key if fn
+// does not keep it. It returns the new item and whether key is
+// present afterwards. fn must not use the map.
+func (h *HashMap) Update(key []byte, fn UpdateFunc) (interface{}, bool) {
+	return h.update(h.hash(key), key, fn)
+}
+
+// update is Update for a key already hashed to hk.
+func (h *HashMap) update(hk uint64, key []byte, fn UpdateFunc) (interface{}, bool) {
+	b, e, n := h.find(hk, key)
+	if *e != nil {
+		data, keep := fn((*e).data, true)
+		if !keep {
+			h.unlink(e)
+			return nil, false
+		}
+		(*e).data = data
+		h.touch(*e)
+		return data, true
+	}
+	data, keep := fn(nil, false)
+	if !keep {
+		return nil, false
+	}
+	h.insert(b, n, hk, key, data)
+	return data, true
+}
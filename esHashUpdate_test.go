@@ -0,0 +1,153 @@
+package esMap
+
+import (
+	"sync"
+	"testing"
+)
+
+// rmwMap is the read-modify-write API shared by the maps.
+type rmwMap interface {
+	Map
+	GetOrSet(key []byte, data interface{}) (interface{}, bool)
+	SetIfAbsent(key []byte, data interface{}) bool
+	Swap(key []byte, data interface{}) (interface{}, bool)
+	CompareAndSwap(key []byte, old, data interface{}) bool
+	CompareAndDelete(key []byte, old interface{}) bool
+	Update(key []byte, fn UpdateFunc) (interface{}, bool)
+}
+
+func rmwMaps() map[string]rmwMap {
+	return map[string]rmwMap{
+		"HashMap":           NewHashMap(),
+		"ConcurrentHashMap": NewConcurrentHashMap(),
+		"RCUHashMap":        NewRCUHashMap(),
+	}
+}
+
+func TestReadModifyWrite(t *testing.T) {
+	for name, m := range rmwMaps() {
+		if v, loaded := m.GetOrSet(foo, 1); loaded || v.(int) != 1 {
+			t.Fatalf("%s: GetOrSet on a missing key = %v, %v\n", name, v, loaded)
+		}
+		if v, loaded := m.GetOrSet(foo, 2); !loaded || v.(int) != 1 {
+			t.Fatalf("%s: GetOrSet on a present key = %v, %v\n", name, v, loaded)
+		}
+		if m.SetIfAbsent(foo, 3) || !m.SetIfAbsent(bar, 3) || m.Get(bar).(int) != 3 {
+			t.Fatalf("%s: SetIfAbsent is wrong\n", name)
+		}
+		if v, loaded := m.Swap(foo, 4); !loaded || v.(int) != 1 || m.Get(foo).(int) != 4 {
+			t.Fatalf("%s: Swap on a present key = %v, %v\n", name, v, loaded)
+		}
+		if v, loaded := m.Swap(baz, 5); loaded || v != nil || m.Get(baz).(int) != 5 {
+			t.Fatalf("%s: Swap on a missing key = %v, %v\n", name, v, loaded)
+		}
+		if m.CompareAndSwap(foo, 1, 6) || m.CompareAndSwap(sub, nil, 6) {
+			t.Fatalf("%s: CompareAndSwap should fail\n", name)
+		}
+		if !m.CompareAndSwap(foo, 4, 6) || m.Get(foo).(int) != 6 {
+			t.Fatalf("%s: CompareAndSwap should succeed\n", name)
+		}
+		if m.CompareAndDelete(foo, 4) || m.CompareAndDelete(sub, nil) || !m.Contains(foo) {
+			t.Fatalf("%s: CompareAndDelete should fail\n", name)
+		}
+		if !m.CompareAndDelete(foo, 6) || m.Contains(foo) {
+			t.Fatalf("%s: CompareAndDelete should succeed\n", name)
+		}
+		inc := func(old interface{}, exists bool) (interface{}, bool) {
+			if !exists {
+				return 1, true
+			}
+			return old.(int) + 1, true
+		}
+		for i := 1; i <= 3; i++ {
+			if v, ok := m.Update(foo, inc); !ok || v.(int) != i {
+				t.Fatalf("%s: Update = %v, %v vs %d\n", name, v, ok, i)
+			}
+		}
+		drop := func(interface{}, bool) (interface{}, bool) { return nil, false }
+		if v, ok := m.Update(foo, drop); ok || v != nil || m.Contains(foo) {
+			t.Fatalf("%s: Update should remove the key\n", name)
+		}
+		if _, ok := m.Update(foo, drop); ok || m.Contains(foo) {
+			t.Fatalf("%s: Update should not add the key\n", name)
+		}
+		if m.Count() != 2 {
+			t.Fatalf("%s: wrong number of entries: %d vs 2\n", name, m.Count())
+		}
+	}
+}
+
+func TestReadModifyWriteUncomparable(t *testing.T) {
+	h := NewHashMap()
+	h.Set(foo, []byte("foo"))
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("CompareAndSwap should panic on uncomparable items\n")
+		}
+	}()
+	h.CompareAndSwap(foo, []byte("foo"), nil)
+}
+
+func TestReadModifyWriteGrow(t *testing.T) {
+	h := NewHashMap(WithIncrementalRehash(1))
+	keys := parallelKeys(10000)
+	for i, k := range keys {
+		if _, loaded := h.GetOrSet(k, i); loaded {
+			t.Fatalf("Key '%s' should be missing\n", k)
+		}
+	}
+	for i, k := range keys {
+		if !h.CompareAndDelete(k, i) {
+			t.Fatalf("Key '%s' should be deleted\n", k)
+		}
+	}
+	if h.Count() != 0 || h.area.freeCnt != h.area.totalCnt {
+		t.Fatalf("Wrong number of entries: %d\n", h.Count())
+	}
+}
+
+func TestReadModifyWriteConcurrent(t *testing.T) {
+	const (
+		workers = 8
+		incs    = 2000
+	)
+	for name, m := range rmwMaps() {
+		if name == "HashMap" {
+			continue
+		}
+		keys := parallelKeys(16)
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < incs; i++ {
+					k := keys[i%len(keys)]
+					if i%2 == 0 {
+						m.Update(k, func(old interface{}, exists bool) (interface{}, bool) {
+							if !exists {
+								return 1, true
+							}
+							return old.(int) + 1, true
+						})
+						continue
+					}
+					for {
+						old, loaded := m.GetOrSet(k, 1)
+						if !loaded || m.CompareAndSwap(k, old, old.(int)+1) {
+							break
+						}
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		sum := 0
+		for _, k := range keys {
+			sum += m.Get(k).(int)
+		}
+		if sum != workers*incs {
+			t.Fatalf("%s: lost updates, %d vs %d\n", name, sum, workers*incs)
+		}
+	}
+}
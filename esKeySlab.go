@@ -0,0 +1,77 @@
+// Slab storage for keys owned by a HashMap.
+package esMap
+
+const (
+	// _KSLAB is the size of a key slab chunk.
+	_KSLAB = 4096
+	// _KSLABMAX is the largest key packed into a chunk; longer keys
+	// get an allocation of their own.
+	_KSLABMAX = _KSLAB >> 3
+	// _KSLABGC is the slab size below which removed keys are left
+	// alone, compacting so few chunks would not pay off.
+	_KSLABGC = 16 * _KSLAB
+)
+
+// keySlab packs copies of keys into large chunks so that owning a key
+// costs no allocation of its own. Chunks are never written again once
+// full, so keys handed out stay valid for as long as they are held.
+// A chunk is only released once none of its keys is referenced any
+// more, so the HashMap compacts the slab when most of it holds
+// removed keys, see compactKeys.
+type keySlab struct {
+	buf []byte
+	// size is the memory of the chunks allocated since the last
+	// compaction, live the part of it taken by keys still present.
+	size int
+	live int
+}
+
+// copy returns a copy of key backed by slab memory. The copy has its
+// capacity clipped to its length so appends never run into a
+// neighbouring key.
+func (s *keySlab) copy(key []byte) []byte {
+	klen := len(key)
+	if klen == 0 {
+		return []byte{}
+	}
+	if klen > _KSLABMAX {
+		return append(make([]byte, 0, klen), key...)
+	}
+	if klen > cap(s.buf)-len(s.buf) {
+		s.buf = make([]byte, 0, _KSLAB)
+		s.size += _KSLAB
+	}
+	off := len(s.buf)
+	s.buf = append(s.buf, key...)
+	s.live += klen
+	return s.buf[off : off+klen : off+klen]
+}
+
+// release accounts for the removal of key, a copy made by copy.
+func (s *keySlab) release(key []byte) {
+	if klen := len(key); klen <= _KSLABMAX {
+		s.live -= klen
+	}
+}
+
+// sparse reports whether removed keys take most of the slab.
+func (s *keySlab) sparse() bool {
+	return s.size > _KSLABGC && s.live < s.size/2
+}
+
+// compactKeys copies the keys packed into the slab into new chunks,
+// leaving the old chunks to the garbage collector. Each compaction
+// follows the removal of at least as many bytes of keys as it
+// copies, so its cost is amortized over those removals.
+func (h *HashMap) compactKeys() {
+	*h.keys = keySlab{}
+	for _, bkts := range h.chains() {
+		for _, e := range bkts {
+			for ; e != nil; e = e.next {
+				if len(e.key) <= _KSLABMAX {
+					e.key = h.keys.copy(e.key)
+				}
+			}
+		}
+	}
+}
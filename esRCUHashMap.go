@@ -0,0 +1,338 @@
+// RCUHashMap is a read-optimized HashMap for read-mostly workloads.
+// Readers take no locks: they load an atomically published bucket
+// array and walk chains whose entries are never modified once they
+// are published. Writers serialize on a mutex and publish their
+// updates copy-on-write, copying the part of a chain in front of the
+// entry they change, and resizes publish a whole new bucket array.
+package esMap
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// rcuEntry is the Entry of an RCUHashMap. It is immutable once
+// it has been published.
+type rcuEntry struct {
+	hk   uint32
+	key  []byte
+	data interface{}
+	next *rcuEntry
+}
+
+// rcuTable is a bucket array of an RCUHashMap.
+type rcuTable struct {
+	bkts []atomic.Pointer[rcuEntry]
+	msk  uint32
+}
+
+func newRCUTable(bkts int) *rcuTable {
+	return &rcuTable{bkts: make([]atomic.Pointer[rcuEntry], bkts), msk: uint32(bkts - 1)}
+}
+
+// RCUHashMap stores rcuEntry items using a given Hash function.
+// The Hash function can be overridden before the map is used. Keys
+// are not copied and must not be modified after Set.
+type RCUHashMap struct {
+	Hash func([]byte) uint32
+	tbl  atomic.Pointer[rcuTable]
+	used atomic.Uint32
+	rsz  bool
+	mu   sync.Mutex // serializes writers
+}
+
+// NewRCUHashMapWithBkts creates a new RCUHashMap with bkts buckets.
+// bkts must be a power of 2.
+func NewRCUHashMapWithBkts(bkts int) (*RCUHashMap, error) {
+	if bkts == 0 || (bkts&(bkts-1) != 0) {
+		return nil, errors.New("Size of buckets must be power of 2")
+	}
+	h := &RCUHashMap{}
+	h.tbl.Store(newRCUTable(bkts))
+	h.Hash = DefaultHash
+	h.rsz = true
+	return h, nil
+}
+
+// NewRCUHashMap creates a new RCUHashMap of default size and using
+// the default Hashing algorithm.
+func NewRCUHashMap() *RCUHashMap {
+	h, _ := NewRCUHashMapWithBkts(_BSZ)
+	return h
+}
+
+// lookup returns the entry holding key with hash hk in t, or nil.
+func (t *rcuTable) lookup(hk uint32, key []byte) *rcuEntry {
+	for e := t.bkts[hk&t.msk].Load(); e != nil; e = e.next {
+		if len(key) == len(e.key) && hk == e.hk && SilceEqui(key, e.key) {
+			return e
+		}
+	}
+	return nil
+}
+
+// Get will return the item at key. Get never blocks.
+func (h *RCUHashMap) Get(key []byte) interface{} {
+	if e := h.tbl.Load().lookup(h.Hash(key), key); e != nil {
+		return e.data
+	}
+	return nil
+}
+
+// Lookup will return the item at key and whether it was found.
+// Lookup never blocks.
+func (h *RCUHashMap) Lookup(key []byte) (interface{}, bool) {
+	if e := h.tbl.Load().lookup(h.Hash(key), key); e != nil {
+		return e.data, true
+	}
+	return nil, false
+}
+
+// Contains reports whether key is present in the RCUHashMap.
+func (h *RCUHashMap) Contains(key []byte) bool {
+	return h.tbl.Load().lookup(h.Hash(key), key) != nil
+}
+
+// replace publishes the chain of bucket b with the entry at position
+// e replaced by ne, or removed when ne is nil. The entries in front
+// of e are copied, the ones behind it are shared with the old chain.
+func (t *rcuTable) replace(b uint32, e, ne *rcuEntry) {
+	head := &t.bkts[b]
+	var first, last *rcuEntry
+	for p := head.Load(); p != e; p = p.next {
+		cp := *p
+		if last == nil {
+			first = &cp
+		} else {
+			last.next = &cp
+		}
+		last = &cp
+	}
+	if ne == nil {
+		ne = e.next
+	} else {
+		ne.next = e.next
+	}
+	if last == nil {
+		first = ne
+	} else {
+		last.next = ne
+	}
+	head.Store(first)
+}
+
+// Set will set the key item to data. This will blindly replace any item
+// that may have been at key previous.
+func (h *RCUHashMap) Set(key []byte, data interface{}) {
+	hk := h.Hash(key)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t := h.tbl.Load()
+	h.store(t, hk, key, t.lookup(hk, key), data)
+}
+
+// store publishes key set to data, replacing e if not nil.
+// h.mu must be held.
+func (h *RCUHashMap) store(t *rcuTable, hk uint32, key []byte, e *rcuEntry, data interface{}) {
+	b := hk & t.msk
+	ne := &rcuEntry{hk: hk, key: key, data: data}
+	if e != nil {
+		t.replace(b, e, ne)
+		return
+	}
+	// We have a new entry here
+	ne.next = t.bkts[b].Load()
+	t.bkts[b].Store(ne)
+	used := h.used.Add(1)
+	// Check for resizing
+	if h.rsz && (used > uint32(len(t.bkts))) && len(t.bkts) < maxBktSize {
+		h.resize(t, uint32(len(t.bkts)<<1))
+	}
+}
+
+// Remove will remove what is associated with key. It returns the
+// removed item and whether anything was removed.
+func (h *RCUHashMap) Remove(key []byte) (interface{}, bool) {
+	hk := h.Hash(key)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t := h.tbl.Load()
+	e := t.lookup(hk, key)
+	if e == nil {
+		return nil, false
+	}
+	h.delete(t, hk, e)
+	return e.data, true
+}
+
+// delete publishes the removal of e. h.mu must be held.
+func (h *RCUHashMap) delete(t *rcuTable, hk uint32, e *rcuEntry) {
+	t.replace(hk&t.msk, e, nil)
+	used := h.used.Add(^uint32(0))
+	// Check for resizing
+	lbkts := uint32(len(t.bkts))
+	if h.rsz && lbkts > _BSZ && (used < lbkts>>2) {
+		h.resize(t, lbkts>>1)
+	}
+}
+
+// GetOrSet is HashMap.GetOrSet done atomically.
+func (h *RCUHashMap) GetOrSet(key []byte, data interface{}) (actual interface{}, loaded bool) {
+	hk := h.Hash(key)
+	if e := h.tbl.Load().lookup(hk, key); e != nil {
+		// Present already, no need to take the lock.
+		return e.data, true
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t := h.tbl.Load()
+	if e := t.lookup(hk, key); e != nil {
+		return e.data, true
+	}
+	h.store(t, hk, key, nil, data)
+	return data, false
+}
+
+// SetIfAbsent is HashMap.SetIfAbsent done atomically.
+func (h *RCUHashMap) SetIfAbsent(key []byte, data interface{}) bool {
+	_, loaded := h.GetOrSet(key, data)
+	return !loaded
+}
+
+// Swap is HashMap.Swap done atomically.
+func (h *RCUHashMap) Swap(key []byte, data interface{}) (previous interface{}, loaded bool) {
+	hk := h.Hash(key)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t := h.tbl.Load()
+	e := t.lookup(hk, key)
+	h.store(t, hk, key, e, data)
+	if e == nil {
+		return nil, false
+	}
+	return e.data, true
+}
+
+// CompareAndSwap is HashMap.CompareAndSwap done atomically.
+func (h *RCUHashMap) CompareAndSwap(key []byte, old, data interface{}) bool {
+	hk := h.Hash(key)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t := h.tbl.Load()
+	e := t.lookup(hk, key)
+	if e == nil || e.data != old {
+		return false
+	}
+	h.store(t, hk, key, e, data)
+	return true
+}
+
+// CompareAndDelete is HashMap.CompareAndDelete done atomically.
+func (h *RCUHashMap) CompareAndDelete(key []byte, old interface{}) bool {
+	hk := h.Hash(key)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t := h.tbl.Load()
+	e := t.lookup(hk, key)
+	if e == nil || e.data != old {
+		return false
+	}
+	h.delete(t, hk, e)
+	return true
+}
+
+// Update is HashMap.Update done atomically. fn runs with writers
+// locked out, so it must be quick and must not use the map.
+func (h *RCUHashMap) Update(key []byte, fn UpdateFunc) (interface{}, bool) {
+	hk := h.Hash(key)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t := h.tbl.Load()
+	e := t.lookup(hk, key)
+	var data interface{}
+	var keep bool
+	if e != nil {
+		data, keep = fn(e.data, true)
+	} else {
+		data, keep = fn(nil, false)
+	}
+	switch {
+	case keep:
+		h.store(t, hk, key, e, data)
+		return data, true
+	case e != nil:
+		h.delete(t, hk, e)
+	}
+	return nil, false
+}
+
+// resize copies all entries of t into a new bucket array and
+// publishes it. Readers still walking t are not affected.
+func (h *RCUHashMap) resize(t *rcuTable, nsz uint32) {
+	nt := newRCUTable(int(nsz))
+	for i := range t.bkts {
+		for e := t.bkts[i].Load(); e != nil; e = e.next {
+			ne := *e
+			b := &nt.bkts[ne.hk&nt.msk]
+			ne.next = b.Load()
+			b.Store(&ne)
+		}
+	}
+	h.tbl.Store(nt)
+}
+
+// Count returns number of elements in the RCUHashMap
+func (h *RCUHashMap) Count() uint32 {
+	return h.used.Load()
+}
+
+// AllKeys will return all the keys stored in the RCUHashMap
+func (h *RCUHashMap) AllKeys() [][]byte {
+	t := h.tbl.Load()
+	all := make([][]byte, 0, h.used.Load())
+	for i := range t.bkts {
+		for e := t.bkts[i].Load(); e != nil; e = e.next {
+			all = append(all, e.key)
+		}
+	}
+	return all
+}
+
+// All returns all the Entries in the RCUHashMap
+func (h *RCUHashMap) All() []interface{} {
+	t := h.tbl.Load()
+	all := make([]interface{}, 0, h.used.Load())
+	for i := range t.bkts {
+		for e := t.bkts[i].Load(); e != nil; e = e.next {
+			all = append(all, e.data)
+		}
+	}
+	return all
+}
+
+// Stats will collect general statistics about the RCUHashMap
+func (h *RCUHashMap) Stats() *Stats {
+	t := h.tbl.Load()
+	lc, totalc, slots := 0, 0, 0
+	for i := range t.bkts {
+		e := t.bkts[i].Load()
+		if e != nil {
+			slots += 1
+		}
+		n := 0
+		for ; e != nil; e = e.next {
+			n += 1
+			if n > lc {
+				lc = n
+			}
+		}
+		totalc += n
+	}
+	return &Stats{
+		NumElements: uint32(totalc),
+		NumBuckets:  uint32(len(t.bkts)),
+		LongChain:   uint32(lc),
+		AvgChain:    float32(totalc) / float32(slots),
+		NumSlots:    uint32(slots)}
+}
@@ -0,0 +1,228 @@
+package esMap
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRCUHashMapBasics(t *testing.T) {
+	if _, err := NewRCUHashMapWithBkts(3); err == nil {
+		t.Fatalf("Buckets size of %d should have failed\n", 3)
+	}
+	h := NewRCUHashMap()
+	h.Set(foo, bar)
+	h.Set(baz, nil)
+	if h.Count() != 2 {
+		t.Fatalf("Wrong number of entries: %d vs 2\n", h.Count())
+	}
+	if v := h.Get(foo); v == nil || string(v.([]byte)) != string(bar) {
+		t.Fatalf("Did not receive correct answer: '%s' vs '%v'\n", bar, v)
+	}
+	if v, ok := h.Lookup(baz); !ok || v != nil {
+		t.Fatalf("Expected stored nil, got %v, %v\n", v, ok)
+	}
+	h.Set(foo, "replaced")
+	if h.Count() != 2 || h.Get(foo).(string) != "replaced" {
+		t.Fatalf("Set should replace\n")
+	}
+	if v, ok := h.Remove(foo); !ok || v.(string) != "replaced" {
+		t.Fatalf("Expected to remove 'replaced', got %v, %v\n", v, ok)
+	}
+	if h.Contains(foo) || h.Count() != 1 {
+		t.Fatalf("foo should have been removed\n")
+	}
+	if _, ok := h.Remove(foo); ok {
+		t.Fatalf("Expected nothing to remove\n")
+	}
+}
+
+func TestRCUHashMapChains(t *testing.T) {
+	h := NewRCUHashMap()
+	h.rsz = false
+	h.Hash = func([]byte) uint32 { return 0 }
+	keys := parallelKeys(16)
+	for i, k := range keys {
+		h.Set(k, i)
+	}
+	// Replace and remove at the head, middle and tail of the chain
+	old := h.tbl.Load().bkts[0].Load()
+	for _, i := range []int{15, 7, 0} {
+		h.Set(keys[i], -i)
+	}
+	for _, i := range []int{14, 8, 1} {
+		h.Remove(keys[i])
+	}
+	for i, k := range keys {
+		v, ok := h.Lookup(k)
+		switch i {
+		case 14, 8, 1:
+			if ok {
+				t.Fatalf("'%s' should have been removed\n", k)
+			}
+		case 15, 7, 0:
+			if !ok || v.(int) != -i {
+				t.Fatalf("'%s' should have been replaced, got %v\n", k, v)
+			}
+		default:
+			if !ok || v.(int) != i {
+				t.Fatalf("'%s' should be %d, got %v\n", k, i, v)
+			}
+		}
+	}
+	// The chain published before the writes is unchanged
+	n := 0
+	for e := old; e != nil; e = e.next {
+		if e.data.(int) != 15-n {
+			t.Fatalf("Old chain was modified at %d: %v\n", n, e.data)
+		}
+		n++
+	}
+	if n != 16 {
+		t.Fatalf("Old chain was modified, length %d vs 16\n", n)
+	}
+}
+
+func TestRCUHashMapGrowShrink(t *testing.T) {
+	h := NewRCUHashMap()
+	keys := parallelKeys(INS)
+	for i, k := range keys {
+		h.Set(k, i)
+	}
+	if s := h.Stats(); s.NumBuckets != EXP || s.NumElements != INS {
+		t.Fatalf("Stats incorrect: %+v\n", s)
+	}
+	if len(h.AllKeys()) != INS || len(h.All()) != INS {
+		t.Fatalf("Expected %d keys and values\n", INS)
+	}
+	for i := 0; i < REM; i++ {
+		h.Remove(keys[i])
+	}
+	if s := h.Stats(); s.NumBuckets != EXP2 {
+		t.Fatalf("Shrunk bucket size is wrong: %d vs %d\n", s.NumBuckets, EXP2)
+	}
+	for i := REM; i < INS; i++ {
+		if v := h.Get(keys[i]); v == nil || v.(int) != i {
+			t.Fatalf("Did not match properly, %v vs %d\n", v, i)
+		}
+	}
+}
+
+// Run with -race to check readers against writers.
+func TestRCUHashMapParallel(t *testing.T) {
+	h := NewRCUHashMap()
+	keys := parallelKeys(4096)
+	// Even keys are always present, odd keys come and go
+	for i := 0; i < len(keys); i += 2 {
+		h.Set(keys[i], i)
+	}
+	var stop int32
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for atomic.LoadInt32(&stop) == 0 {
+				for i := 0; i < len(keys); i += 2 {
+					if v, ok := h.Lookup(keys[i]); !ok || v.(int) != i {
+						t.Errorf("Lookup('%s') = %v, %v vs %d\n", keys[i], v, ok, i)
+						return
+					}
+				}
+			}
+		}()
+	}
+	for n := 0; n < 4; n++ {
+		for i := 1; i < len(keys); i += 2 {
+			h.Set(keys[i], i)
+		}
+		for i := 1; i < len(keys); i += 2 {
+			h.Remove(keys[i])
+		}
+	}
+	atomic.StoreInt32(&stop, 1)
+	wg.Wait()
+	if h.Count() != uint32(len(keys)/2) {
+		t.Fatalf("Wrong number of entries: %d vs %d\n", h.Count(), len(keys)/2)
+	}
+}
+
+func benchmark_RCUHashMap_GetSmallKey(b *testing.B, size int) {
+	b.StopTimer()
+	m := NewRCUHashMap()
+	keys := make([][]byte, size)
+	for i := 0; i < len(keys); i++ {
+		keys[i] = []byte(fmt.Sprintf("foo.%d", i))
+		m.Set(keys[i], bar)
+	}
+	b.StartTimer()
+
+	Grp := b.N / size
+	for g := 0; g < Grp; g++ {
+		for i := 0; i < size; i++ {
+			_ = m.Get(keys[i])
+		}
+	}
+}
+
+func Benchmark_RCUMap__GetSmallKey_64(b *testing.B) {
+	benchmark_RCUHashMap_GetSmallKey(b, 64)
+}
+func Benchmark_RCUMap__GetSmallKey_1024(b *testing.B) {
+	benchmark_RCUHashMap_GetSmallKey(b, 1024)
+}
+func Benchmark_RCUMap__GetSmallKey__8192(b *testing.B) {
+	benchmark_RCUHashMap_GetSmallKey(b, 8192)
+}
+
+// rwLockedHashMap shares a HashMap between many readers.
+type rwLockedHashMap struct {
+	sync.RWMutex
+	*HashMap
+}
+
+// benchmarkParallelRead runs Gets of small keys only.
+func benchmarkParallelRead(b *testing.B, set func([]byte), get func([]byte)) {
+	keys := parallelKeys(1024)
+	for _, k := range keys {
+		set(k)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			get(keys[i&(len(keys)-1)])
+			i += 7
+		}
+	})
+}
+
+func Benchmark_ParallelRead_RWLockedMap(b *testing.B) {
+	m := rwLockedHashMap{HashMap: NewHashMap()}
+	benchmarkParallelRead(b,
+		func(k []byte) { m.Lock(); m.Set(k, boxed); m.Unlock() },
+		func(k []byte) { m.RLock(); m.Get(k); m.RUnlock() })
+}
+
+func Benchmark_ParallelRead_Concurrent_(b *testing.B) {
+	c := NewConcurrentHashMap()
+	benchmarkParallelRead(b,
+		func(k []byte) { c.Set(k, boxed) },
+		func(k []byte) { c.Get(k) })
+}
+
+func Benchmark_ParallelRead_SyncMap____(b *testing.B) {
+	var m sync.Map
+	benchmarkParallelRead(b,
+		func(k []byte) { m.Store(string(k), boxed) },
+		func(k []byte) { m.Load(string(k)) })
+}
+
+func Benchmark_ParallelRead_RCUHashMap_(b *testing.B) {
+	m := NewRCUHashMap()
+	benchmarkParallelRead(b,
+		func(k []byte) { m.Set(k, boxed) },
+		func(k []byte) { m.Get(k) })
+}
@@ -0,0 +1,228 @@
+// RobinHoodMap is an open-addressing hashmap using Robin Hood hashing
+// with linear probing. An entry being inserted takes the slot of any
+// entry closer to its home slot than itself, which keeps the probe
+// distances of all entries short and even, lets lookups of missing
+// keys stop early, and allows load factors of 0.9. Removal shifts
+// the following entries back instead of leaving tombstones.
+package esMap
+
+import (
+	"errors"
+)
+
+// _RHLOAD is the maximum load factor of a RobinHoodMap, in tenths.
+const _RHLOAD = 9
+
+// rhSlot is the Entry of a RobinHoodMap.
+type rhSlot struct {
+	hk   uint32
+	dist uint32 // probe distance from the home slot plus 1, 0 if empty
+	key  []byte
+	data interface{}
+}
+
+// RobinHoodMap stores rhSlot items using a given Hash function.
+// The Hash function can be overridden before the map is used.
+type RobinHoodMap struct {
+	Hash   func([]byte) uint32
+	slots  []rhSlot
+	msk    uint32
+	used   uint32
+	growAt uint32
+}
+
+// NewRobinHoodMapWithBkts creates a new RobinHoodMap with bkts slots.
+// bkts must be a power of 2.
+func NewRobinHoodMapWithBkts(bkts int) (*RobinHoodMap, error) {
+	if bkts == 0 || (bkts&(bkts-1) != 0) {
+		return nil, errors.New("Size of buckets must be power of 2")
+	}
+	h := &RobinHoodMap{Hash: DefaultHash}
+	h.alloc(bkts)
+	return h, nil
+}
+
+// NewRobinHoodMap creates a new RobinHoodMap of default size and
+// using the default Hashing algorithm.
+func NewRobinHoodMap() *RobinHoodMap {
+	h, _ := NewRobinHoodMapWithBkts(_BSZ)
+	return h
+}
+
+// alloc sets up an empty array of n slots.
+func (h *RobinHoodMap) alloc(n int) {
+	h.slots = make([]rhSlot, n)
+	h.msk = uint32(n - 1)
+	h.growAt = uint32(uint64(n) * _RHLOAD / 10)
+	if h.growAt == uint32(n) {
+		h.growAt--
+	}
+}
+
+// find returns the slot holding key with hash hk, or -1.
+func (h *RobinHoodMap) find(hk uint32, key []byte) int {
+	i := hk & h.msk
+	for d := uint32(1); ; d++ {
+		e := &h.slots[i]
+		// Every entry from here on is closer to its home
+		// than key would be, so key cannot be further on.
+		if e.dist < d {
+			return -1
+		}
+		if e.hk == hk && SilceEqui(key, e.key) {
+			return int(i)
+		}
+		i = (i + 1) & h.msk
+	}
+}
+
+// Set will set the key item to data. This will blindly replace any item
+// that may have been at key previous.
+func (h *RobinHoodMap) Set(key []byte, data interface{}) {
+	hk := h.Hash(key)
+	if s := h.find(hk, key); s >= 0 {
+		// Success, replace data field
+		h.slots[s].data = data
+		return
+	}
+	// We have a new entry here
+	if h.used >= h.growAt {
+		h.resize(len(h.slots) << 1)
+	}
+	h.insert(rhSlot{hk: hk, key: key, data: data})
+}
+
+// insert places an entry known not to be present, displacing the
+// entries closer to their home slot along the way.
+func (h *RobinHoodMap) insert(ne rhSlot) {
+	i := ne.hk & h.msk
+	ne.dist = 1
+	for {
+		e := &h.slots[i]
+		if e.dist == 0 {
+			*e = ne
+			h.used++
+			return
+		}
+		if e.dist < ne.dist {
+			// Take from the rich, carry on with the displaced
+			*e, ne = ne, *e
+		}
+		ne.dist++
+		i = (i + 1) & h.msk
+	}
+}
+
+// Get will return the item at key.
+func (h *RobinHoodMap) Get(key []byte) interface{} {
+	if s := h.find(h.Hash(key), key); s >= 0 {
+		return h.slots[s].data
+	}
+	return nil
+}
+
+// Lookup will return the item at key and whether it was found.
+func (h *RobinHoodMap) Lookup(key []byte) (interface{}, bool) {
+	if s := h.find(h.Hash(key), key); s >= 0 {
+		return h.slots[s].data, true
+	}
+	return nil, false
+}
+
+// Contains reports whether key is present in the RobinHoodMap.
+func (h *RobinHoodMap) Contains(key []byte) bool {
+	return h.find(h.Hash(key), key) >= 0
+}
+
+// Remove will remove what is associated with key. It returns the
+// removed item and whether anything was removed.
+func (h *RobinHoodMap) Remove(key []byte) (interface{}, bool) {
+	s := h.find(h.Hash(key), key)
+	if s < 0 {
+		return nil, false
+	}
+	data := h.slots[s].data
+	// Backward shift: pull the following entries one slot closer
+	// to their home until one is at home or the run ends.
+	i := uint32(s)
+	for {
+		j := (i + 1) & h.msk
+		next := &h.slots[j]
+		if next.dist <= 1 {
+			break
+		}
+		h.slots[i] = *next
+		h.slots[i].dist--
+		i = j
+	}
+	h.slots[i] = rhSlot{}
+	h.used--
+	// Check for resizing
+	if n := len(h.slots); n > _BSZ && h.used < uint32(n>>3) {
+		h.resize(n >> 1)
+	}
+	return data, true
+}
+
+// resize moves all entries into a new array of n slots.
+func (h *RobinHoodMap) resize(n int) {
+	slots := h.slots
+	h.alloc(n)
+	h.used = 0
+	for i := range slots {
+		if slots[i].dist != 0 {
+			h.insert(slots[i])
+		}
+	}
+}
+
+// Count returns number of elements in the RobinHoodMap
+func (h *RobinHoodMap) Count() uint32 {
+	return h.used
+}
+
+// AllKeys will return all the keys stored in the RobinHoodMap
+func (h *RobinHoodMap) AllKeys() [][]byte {
+	all := make([][]byte, 0, h.used)
+	for i := range h.slots {
+		if h.slots[i].dist != 0 {
+			all = append(all, h.slots[i].key)
+		}
+	}
+	return all
+}
+
+// All returns all the Entries in the RobinHoodMap
+func (h *RobinHoodMap) All() []interface{} {
+	all := make([]interface{}, 0, h.used)
+	for i := range h.slots {
+		if h.slots[i].dist != 0 {
+			all = append(all, h.slots[i].data)
+		}
+	}
+	return all
+}
+
+// Stats will collect general statistics about the RobinHoodMap,
+// with the probe distances of the entries from their home slots.
+func (h *RobinHoodMap) Stats() *Stats {
+	var mp, total uint32
+	for i := range h.slots {
+		if d := h.slots[i].dist; d != 0 {
+			total += d - 1
+			if d-1 > mp {
+				mp = d - 1
+			}
+		}
+	}
+	s := &Stats{
+		NumElements: h.used,
+		NumSlots:    h.used,
+		NumBuckets:  uint32(len(h.slots)),
+		MaxProbe:    mp,
+	}
+	if h.used > 0 {
+		s.AvgProbe = float32(total) / float32(h.used)
+	}
+	return s
+}
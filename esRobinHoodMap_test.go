@@ -0,0 +1,168 @@
+package esMap
+
+import (
+	"fmt"
+	mrand "math/rand"
+	"testing"
+)
+
+func TestRobinHoodMapBasics(t *testing.T) {
+	if _, err := NewRobinHoodMapWithBkts(3); err == nil {
+		t.Fatalf("Buckets size of %d should have failed\n", 3)
+	}
+	h := NewRobinHoodMap()
+	h.Set(foo, bar)
+	h.Set(baz, nil)
+	h.Set([]byte{}, "empty")
+	if h.Count() != 3 {
+		t.Fatalf("Wrong number of entries: %d vs 3\n", h.Count())
+	}
+	if v := h.Get(foo); v == nil || string(v.([]byte)) != string(bar) {
+		t.Fatalf("Did not receive correct answer: '%s' vs '%v'\n", bar, v)
+	}
+	if v, ok := h.Lookup(baz); !ok || v != nil {
+		t.Fatalf("Expected stored nil, got %v, %v\n", v, ok)
+	}
+	if v := h.Get(nil); v == nil || v.(string) != "empty" {
+		t.Fatalf("Expected empty key, got %v\n", v)
+	}
+	if v, ok := h.Remove(foo); !ok || string(v.([]byte)) != string(bar) {
+		t.Fatalf("Expected to remove bar, got %v, %v\n", v, ok)
+	}
+	if h.Contains(foo) || h.Count() != 2 {
+		t.Fatalf("foo should have been removed\n")
+	}
+	if len(h.AllKeys()) != 2 || len(h.All()) != 2 {
+		t.Fatalf("Expected 2 keys and values\n")
+	}
+}
+
+func TestRobinHoodMapBackwardShift(t *testing.T) {
+	h, _ := NewRobinHoodMapWithBkts(16)
+	// Keys hash to their first byte, so we control the home slots.
+	h.Hash = func(k []byte) uint32 { return uint32(k[0]) }
+	keys := [][]byte{{3, 'a'}, {3, 'b'}, {4, 'a'}, {3, 'c'}, {5, 'a'}, {9, 'a'}}
+	for i, k := range keys {
+		h.Set(k, i)
+	}
+	// 3a 3b 3c 4a 5a at slots 3..7, 9a at home
+	h.Remove(keys[1])
+	exp := map[uint32][]byte{3: {3, 'a'}, 4: {3, 'c'}, 5: {4, 'a'}, 6: {5, 'a'}, 9: {9, 'a'}}
+	for i := range h.slots {
+		e := &h.slots[i]
+		k, ok := exp[uint32(i)]
+		if ok != (e.dist != 0) || ok && string(k) != string(e.key) {
+			t.Fatalf("Slot %d holds %v, expected %v\n", i, e.key, k)
+		}
+		if ok && e.dist != uint32(i)-uint32(k[0])+1 {
+			t.Fatalf("Slot %d has distance %d\n", i, e.dist)
+		}
+	}
+}
+
+func TestRobinHoodMapRandom(t *testing.T) {
+	h := NewRobinHoodMap()
+	ref := make(map[string]int)
+	r := mrand.New(mrand.NewSource(1))
+	for i := 0; i < 200000; i++ {
+		k := fmt.Sprintf("foo.%d", r.Intn(4000))
+		switch r.Intn(3) {
+		case 0:
+			h.Set([]byte(k), i)
+			ref[k] = i
+		case 1:
+			_, ok1 := h.Remove([]byte(k))
+			_, ok2 := ref[k]
+			delete(ref, k)
+			if ok1 != ok2 {
+				t.Fatalf("Remove('%s') = %v vs %v\n", k, ok1, ok2)
+			}
+		case 2:
+			v, ok1 := h.Lookup([]byte(k))
+			rv, ok2 := ref[k]
+			if ok1 != ok2 || ok1 && v.(int) != rv {
+				t.Fatalf("Lookup('%s') = %v, %v vs %v, %v\n", k, v, ok1, rv, ok2)
+			}
+		}
+		if h.Count() != uint32(len(ref)) {
+			t.Fatalf("Wrong number of entries: %d vs %d\n", h.Count(), len(ref))
+		}
+	}
+	for k := range ref {
+		h.Remove([]byte(k))
+	}
+	if h.Count() != 0 || len(h.slots) != _BSZ {
+		t.Fatalf("Expected an empty map of %d slots, got %d of %d\n", _BSZ, h.Count(), len(h.slots))
+	}
+}
+
+func TestRobinHoodMapHighLoad(t *testing.T) {
+	n := 1 << 16
+	h, _ := NewRobinHoodMapWithBkts(n)
+	keys := parallelKeys(int(h.growAt))
+	for i, k := range keys {
+		h.Set(k, i)
+	}
+	s := h.Stats()
+	if s.NumBuckets != uint32(n) {
+		t.Fatalf("Should not have grown at load 0.9: %d vs %d\n", s.NumBuckets, n)
+	}
+	// Linear probing averages (1+1/(1-a))/2 = 5.5 probes at a = 0.9,
+	// Robin Hood keeps the maximum close to the mean.
+	if s.AvgProbe > 6 || s.MaxProbe > 100 {
+		t.Fatalf("Probe distances too long at load 0.9: %+v\n", s)
+	}
+	for i, k := range keys {
+		if v := h.Get(k); v == nil || v.(int) != i {
+			t.Fatalf("Did not match properly, %v vs %d\n", v, i)
+		}
+	}
+}
+
+// benchmarkMissLoad9 looks up missing keys in maps loaded to 0.9.
+func benchmarkMissLoad9(b *testing.B, m Map) {
+	keys := parallelKeys(58982)
+	for _, k := range keys {
+		m.Set(k, boxed)
+	}
+	miss := make([][]byte, 1024)
+	for i := range miss {
+		miss[i] = []byte(fmt.Sprintf("bar.%d", i))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Get(miss[i&(len(miss)-1)])
+	}
+}
+
+func Benchmark_HashMap______MissLoad9(b *testing.B) {
+	h, _ := NewHashMapWithOptions(Options{MaxLoadFactor: 0.9, MinBuckets: 1 << 16})
+	benchmarkMissLoad9(b, h)
+}
+
+func Benchmark_SwissMap_____MissLoad9(b *testing.B) {
+	h, _ := NewSwissMapWithBkts(1 << 16)
+	benchmarkMissLoad9(b, h)
+}
+
+func Benchmark_RobinHoodMap_MissLoad9(b *testing.B) {
+	h, _ := NewRobinHoodMapWithBkts(1 << 16)
+	benchmarkMissLoad9(b, h)
+}
+
+func Benchmark_RobinHoodMap_GetSmallKey_1024(b *testing.B) {
+	size := 1024
+	m := NewRobinHoodMap()
+	keys := parallelKeys(size)
+	for _, k := range keys {
+		m.Set(k, bar)
+	}
+	b.ResetTimer()
+
+	Grp := b.N / size
+	for g := 0; g < Grp; g++ {
+		for i := 0; i < size; i++ {
+			_ = m.Get(keys[i])
+		}
+	}
+}
@@ -0,0 +1,278 @@
+// SwissMap is an open-addressing hashmap using the Swiss table layout
+// of Abseil. Slots are arranged in groups of 8 with one control byte
+// per slot holding 7 bits of the hash, so a whole group is matched
+// against a key at once with portable 64 bit word tricks, and only
+// slots whose control byte matches have their keys compared.
+package esMap
+
+import (
+	"errors"
+	"math/bits"
+)
+
+// Control bytes. A full slot holds the low 7 bits of its hash.
+const (
+	_GSZ      = 8    // slots per group
+	_CEMPTY   = 0x80 // never used slot, ends a probe sequence
+	_CDELETED = 0xFE // tombstone of a removed entry
+	_CMASK    = 0x7F // bits of the hash kept in the control byte
+
+	lsbs = 0x0101010101010101
+	msbs = 0x8080808080808080
+)
+
+// swissSlot is the Entry of a SwissMap.
+type swissSlot struct {
+	hk   uint32
+	key  []byte
+	data interface{}
+}
+
+// SwissMap stores swissSlot items using a given Hash function.
+// The Hash function can be overridden before the map is used.
+type SwissMap struct {
+	Hash   func([]byte) uint32
+	ctrl   []uint64 // control bytes, one word per group
+	slots  []swissSlot
+	gmsk   uint32
+	used   uint32
+	dead   uint32 // tombstones
+	growAt uint32
+}
+
+// NewSwissMapWithBkts creates a new SwissMap with room for bkts slots.
+// bkts must be a power of 2 of at least 8.
+func NewSwissMapWithBkts(bkts int) (*SwissMap, error) {
+	if bkts < _GSZ || (bkts&(bkts-1) != 0) {
+		return nil, errors.New("Size of buckets must be power of 2 of at least 8")
+	}
+	h := &SwissMap{Hash: DefaultHash}
+	h.alloc(bkts)
+	return h, nil
+}
+
+// NewSwissMap creates a new SwissMap of default size and using the
+// default Hashing algorithm.
+func NewSwissMap() *SwissMap {
+	h, _ := NewSwissMapWithBkts(_BSZ)
+	return h
+}
+
+// alloc sets up empty arrays for n slots.
+func (h *SwissMap) alloc(n int) {
+	h.ctrl = make([]uint64, n/_GSZ)
+	for i := range h.ctrl {
+		h.ctrl[i] = lsbs * _CEMPTY
+	}
+	h.slots = make([]swissSlot, n)
+	h.gmsk = uint32(len(h.ctrl) - 1)
+	h.dead = 0
+	// Keep at least 1/8 of the slots empty so probing ends.
+	h.growAt = uint32(n - n/8)
+}
+
+// matchByte returns the bytes of the group word c equal to b, as the
+// high bit of each byte. It can report false positives for a byte
+// following a match, which the comparison of the keys weeds out.
+func matchByte(c uint64, b uint8) uint64 {
+	x := c ^ (lsbs * uint64(b))
+	return (x - lsbs) &^ x & msbs
+}
+
+// matchEmpty returns the empty bytes of the group word c.
+func matchEmpty(c uint64) uint64 {
+	return c &^ (c << 6) & msbs
+}
+
+// matchFree returns the empty or deleted bytes of the group word c.
+func matchFree(c uint64) uint64 {
+	return c & msbs
+}
+
+// setCtrl sets the control byte of slot s to b.
+func (h *SwissMap) setCtrl(s uint32, b uint8) {
+	shift := (s % _GSZ) * 8
+	w := &h.ctrl[s/_GSZ]
+	*w = *w&^(0xFF<<shift) | uint64(b)<<shift
+}
+
+// find returns the slot holding key with hash hk, or -1.
+func (h *SwissMap) find(hk uint32, key []byte) int {
+	h2 := uint8(hk & _CMASK)
+	g := (hk >> 7) & h.gmsk
+	for i := uint32(1); ; i++ {
+		c := h.ctrl[g]
+		for m := matchByte(c, h2); m != 0; m &= m - 1 {
+			s := g*_GSZ + uint32(bits.TrailingZeros64(m)/8)
+			if e := &h.slots[s]; e.hk == hk && SilceEqui(key, e.key) {
+				return int(s)
+			}
+		}
+		if matchEmpty(c) != 0 {
+			return -1
+		}
+		// Triangular probing visits every group
+		g = (g + i) & h.gmsk
+	}
+}
+
+// free returns the first empty or deleted slot for hash hk.
+func (h *SwissMap) free(hk uint32) uint32 {
+	g := (hk >> 7) & h.gmsk
+	for i := uint32(1); ; i++ {
+		if m := matchFree(h.ctrl[g]); m != 0 {
+			return g*_GSZ + uint32(bits.TrailingZeros64(m)/8)
+		}
+		g = (g + i) & h.gmsk
+	}
+}
+
+// Set will set the key item to data. This will blindly replace any item
+// that may have been at key previous.
+func (h *SwissMap) Set(key []byte, data interface{}) {
+	hk := h.Hash(key)
+	if s := h.find(hk, key); s >= 0 {
+		// Success, replace data field
+		h.slots[s].data = data
+		return
+	}
+	// We have a new entry here
+	if h.used+h.dead >= h.growAt {
+		if h.dead >= h.used>>1 {
+			// Mostly tombstones, clean up in place
+			h.resize(len(h.slots))
+		} else {
+			h.resize(len(h.slots) << 1)
+		}
+	}
+	h.insert(hk, key, data)
+}
+
+// insert places a key known not to be present.
+func (h *SwissMap) insert(hk uint32, key []byte, data interface{}) {
+	s := h.free(hk)
+	if h.ctrl[s/_GSZ]>>((s%_GSZ)*8)&0xFF == _CDELETED {
+		h.dead--
+	}
+	h.setCtrl(s, uint8(hk&_CMASK))
+	h.slots[s] = swissSlot{hk: hk, key: key, data: data}
+	h.used++
+}
+
+// Get will return the item at key.
+func (h *SwissMap) Get(key []byte) interface{} {
+	if s := h.find(h.Hash(key), key); s >= 0 {
+		return h.slots[s].data
+	}
+	return nil
+}
+
+// Lookup will return the item at key and whether it was found.
+func (h *SwissMap) Lookup(key []byte) (interface{}, bool) {
+	if s := h.find(h.Hash(key), key); s >= 0 {
+		return h.slots[s].data, true
+	}
+	return nil, false
+}
+
+// Contains reports whether key is present in the SwissMap.
+func (h *SwissMap) Contains(key []byte) bool {
+	return h.find(h.Hash(key), key) >= 0
+}
+
+// Remove will remove what is associated with key. It returns the
+// removed item and whether anything was removed.
+func (h *SwissMap) Remove(key []byte) (interface{}, bool) {
+	s := h.find(h.Hash(key), key)
+	if s < 0 {
+		return nil, false
+	}
+	data := h.slots[s].data
+	h.slots[s] = swissSlot{}
+	// A group with an empty slot never ended a probe sequence
+	// running past it, so the slot can become empty again.
+	if matchEmpty(h.ctrl[s/_GSZ]) != 0 {
+		h.setCtrl(uint32(s), _CEMPTY)
+	} else {
+		h.setCtrl(uint32(s), _CDELETED)
+		h.dead++
+	}
+	h.used--
+	// Check for resizing
+	if n := len(h.slots); n > _BSZ && h.used < uint32(n>>3) {
+		h.resize(n >> 1)
+	}
+	return data, true
+}
+
+// resize moves all entries into new arrays of n slots,
+// which drops all tombstones.
+func (h *SwissMap) resize(n int) {
+	slots, ctrl := h.slots, h.ctrl
+	h.alloc(n)
+	h.used = 0
+	for g, c := range ctrl {
+		for m := ^c & msbs; m != 0; m &= m - 1 {
+			e := &slots[g*_GSZ+bits.TrailingZeros64(m)/8]
+			h.insert(e.hk, e.key, e.data)
+		}
+	}
+}
+
+// Count returns number of elements in the SwissMap
+func (h *SwissMap) Count() uint32 {
+	return h.used
+}
+
+// AllKeys will return all the keys stored in the SwissMap
+func (h *SwissMap) AllKeys() [][]byte {
+	all := make([][]byte, 0, h.used)
+	for g, c := range h.ctrl {
+		for m := ^c & msbs; m != 0; m &= m - 1 {
+			all = append(all, h.slots[g*_GSZ+bits.TrailingZeros64(m)/8].key)
+		}
+	}
+	return all
+}
+
+// All returns all the Entries in the SwissMap
+func (h *SwissMap) All() []interface{} {
+	all := make([]interface{}, 0, h.used)
+	for g, c := range h.ctrl {
+		for m := ^c & msbs; m != 0; m &= m - 1 {
+			all = append(all, h.slots[g*_GSZ+bits.TrailingZeros64(m)/8].data)
+		}
+	}
+	return all
+}
+
+// Stats will collect general statistics about the SwissMap. The
+// probe distance of an entry is the number of groups probed before
+// the one holding it.
+func (h *SwissMap) Stats() *Stats {
+	var mp, total uint32
+	for g, c := range h.ctrl {
+		for m := ^c & msbs; m != 0; m &= m - 1 {
+			e := &h.slots[g*_GSZ+bits.TrailingZeros64(m)/8]
+			d := uint32(0)
+			for p, i := (e.hk>>7)&h.gmsk, uint32(1); p != uint32(g); i++ {
+				p = (p + i) & h.gmsk
+				d++
+			}
+			total += d
+			if d > mp {
+				mp = d
+			}
+		}
+	}
+	s := &Stats{
+		NumElements: h.used,
+		NumSlots:    h.used,
+		NumBuckets:  uint32(len(h.slots)),
+		MaxProbe:    mp,
+	}
+	if h.used > 0 {
+		s.AvgProbe = float32(total) / float32(h.used)
+	}
+	return s
+}
@@ -0,0 +1,135 @@
+package esMap
+
+import (
+	"fmt"
+	mrand "math/rand"
+	"testing"
+)
+
+func TestSwissMapWithBkts(t *testing.T) {
+	for _, n := range []int{0, 3, 4, 12} {
+		if _, err := NewSwissMapWithBkts(n); err == nil {
+			t.Fatalf("Buckets size of %d should have failed\n", n)
+		}
+	}
+	if _, err := NewSwissMapWithBkts(64); err != nil {
+		t.Fatalf("Buckets size of %d should have succeeded\n", 64)
+	}
+}
+
+func TestMatchByte(t *testing.T) {
+	c := uint64(0x80_fe_05_11_05_80_7f_05)
+	if m := matchByte(c, 0x05); m != 0x00_00_80_00_80_00_00_80 {
+		t.Fatalf("matchByte wrong: %016x\n", m)
+	}
+	if m := matchEmpty(c); m != 0x80_00_00_00_00_80_00_00 {
+		t.Fatalf("matchEmpty wrong: %016x\n", m)
+	}
+	if m := matchFree(c); m != 0x80_80_00_00_00_80_00_00 {
+		t.Fatalf("matchFree wrong: %016x\n", m)
+	}
+}
+
+func TestSwissMapBasics(t *testing.T) {
+	h := NewSwissMap()
+	h.Set(foo, bar)
+	h.Set(baz, nil)
+	h.Set([]byte{}, "empty")
+	if h.Count() != 3 {
+		t.Fatalf("Wrong number of entries: %d vs 3\n", h.Count())
+	}
+	if v := h.Get(foo); v == nil || string(v.([]byte)) != string(bar) {
+		t.Fatalf("Did not receive correct answer: '%s' vs '%v'\n", bar, v)
+	}
+	if v, ok := h.Lookup(baz); !ok || v != nil {
+		t.Fatalf("Expected stored nil, got %v, %v\n", v, ok)
+	}
+	if v := h.Get(nil); v == nil || v.(string) != "empty" {
+		t.Fatalf("Expected empty key, got %v\n", v)
+	}
+	h.Set(foo, "replaced")
+	if h.Count() != 3 || h.Get(foo).(string) != "replaced" {
+		t.Fatalf("Set should replace\n")
+	}
+	if v, ok := h.Remove(foo); !ok || v.(string) != "replaced" {
+		t.Fatalf("Expected to remove 'replaced', got %v, %v\n", v, ok)
+	}
+	if h.Contains(foo) || h.Count() != 2 {
+		t.Fatalf("foo should have been removed\n")
+	}
+	if len(h.AllKeys()) != 2 || len(h.All()) != 2 {
+		t.Fatalf("Expected 2 keys and values\n")
+	}
+}
+
+func TestSwissMapCollisions(t *testing.T) {
+	h := NewSwissMap()
+	// Every key has the same hash, so every group is probed
+	// and only the key comparison tells them apart.
+	h.Hash = func([]byte) uint32 { return 42 }
+	keys := parallelKeys(INS)
+	for i, k := range keys {
+		h.Set(k, i)
+	}
+	for i, k := range keys {
+		if v, ok := h.Lookup(k); !ok || v.(int) != i {
+			t.Fatalf("Lookup('%s') = %v, %v vs %d\n", k, v, ok, i)
+		}
+	}
+	s := h.Stats()
+	if s.NumElements != INS || s.MaxProbe == 0 {
+		t.Fatalf("Stats incorrect: %+v\n", s)
+	}
+	for _, k := range keys[:REM] {
+		h.Remove(k)
+	}
+	for i, k := range keys {
+		if _, ok := h.Lookup(k); ok != (i >= REM) {
+			t.Fatalf("Lookup('%s') found %v\n", k, ok)
+		}
+	}
+}
+
+func TestSwissMapRandom(t *testing.T) {
+	h := NewSwissMap()
+	ref := make(map[string]int)
+	r := mrand.New(mrand.NewSource(1))
+	for i := 0; i < 200000; i++ {
+		// Grow to a few thousand keys then shrink back
+		n := 4000
+		if i == 100000 {
+			for k := range ref {
+				h.Remove([]byte(k))
+				delete(ref, k)
+			}
+		}
+		if i >= 100000 {
+			n = 40
+		}
+		k := fmt.Sprintf("foo.%d", r.Intn(n))
+		switch r.Intn(3) {
+		case 0:
+			h.Set([]byte(k), i)
+			ref[k] = i
+		case 1:
+			_, ok1 := h.Remove([]byte(k))
+			_, ok2 := ref[k]
+			delete(ref, k)
+			if ok1 != ok2 {
+				t.Fatalf("Remove('%s') = %v vs %v\n", k, ok1, ok2)
+			}
+		case 2:
+			v, ok1 := h.Lookup([]byte(k))
+			rv, ok2 := ref[k]
+			if ok1 != ok2 || ok1 && v.(int) != rv {
+				t.Fatalf("Lookup('%s') = %v, %v vs %v, %v\n", k, v, ok1, rv, ok2)
+			}
+		}
+		if h.Count() != uint32(len(ref)) {
+			t.Fatalf("Wrong number of entries: %d vs %d\n", h.Count(), len(ref))
+		}
+	}
+	if len(h.slots) > 1024 {
+		t.Fatalf("SwissMap did not shrink: %d slots\n", len(h.slots))
+	}
+}
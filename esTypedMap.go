@@ -0,0 +1,235 @@
+// TypedMap is the type-safe counterpart of HashMap. It keeps the same
+// chained bucket design, growing and shrinking policy and statistics,
+// but stores keys and values with their own types so callers never
+// need type assertions and values are not boxed into interfaces.
+package esMap
+
+import (
+	"bytes"
+	"errors"
+	"unsafe"
+)
+
+// Key is the set of key types a TypedMap can hash directly.
+type Key interface {
+	~[]byte | ~string
+}
+
+// typedEntry is the Entry of a TypedMap.
+type typedEntry[K Key, V any] struct {
+	hk   uint32
+	key  K
+	data V
+	next *typedEntry[K, V]
+}
+
+// TypedMap stores typedEntry items using a given Hash function.
+// The Hash function can be overridden.
+type TypedMap[K Key, V any] struct {
+	Hash func([]byte) uint32
+	bkts []*typedEntry[K, V]
+	msk  uint32
+	used uint32
+	rsz  bool
+}
+
+// NewTypedMapWithBkts creates a new TypedMap with bkts buckets.
+// bkts must be a power of 2.
+func NewTypedMapWithBkts[K Key, V any](bkts int) (*TypedMap[K, V], error) {
+	if bkts == 0 || (bkts&(bkts-1) != 0) {
+		return nil, errors.New("Size of buckets must be power of 2")
+	}
+	h := TypedMap[K, V]{}
+	h.msk = uint32(bkts - 1)
+	h.bkts = make([]*typedEntry[K, V], bkts)
+	h.Hash = DefaultHash
+	h.rsz = true
+	return &h, nil
+}
+
+// NewTypedMap creates a new TypedMap of default size and using the
+// default Hashing algorithm.
+func NewTypedMap[K Key, V any]() *TypedMap[K, V] {
+	h, _ := NewTypedMapWithBkts[K, V](_BSZ)
+	return h
+}
+
+// keyBytes returns the bytes of key without copying them. The only
+// types in Key are strings and byte slices, which differ in size, so
+// the size of the key tells us which header we are looking at.
+func keyBytes[K Key](key *K) []byte {
+	if unsafe.Sizeof(*key) == unsafe.Sizeof("") {
+		s := *(*string)(unsafe.Pointer(key))
+		return unsafe.Slice(unsafe.StringData(s), len(s))
+	}
+	return *(*[]byte)(unsafe.Pointer(key))
+}
+
+// Set will set the key item to data. This will blindly replace any item
+// that may have been at key previous.
+func (h *TypedMap[K, V]) Set(key K, data V) {
+	kb := keyBytes(&key)
+	hk := h.Hash(kb)
+	e := h.bkts[hk&h.msk]
+	for e != nil {
+		if len(kb) == len(e.key) && hk == e.hk && bytes.Equal(kb, keyBytes(&e.key)) {
+			// Success, replace data field
+			e.data = data
+			return
+		}
+		e = e.next
+	}
+	// We have a new entry here
+	ne := &typedEntry[K, V]{hk: hk, key: key, data: data}
+	ne.next = h.bkts[hk&h.msk]
+	h.bkts[hk&h.msk] = ne
+	h.used += 1
+	// Check for resizing
+	if h.rsz && (h.used > uint32(len(h.bkts))) {
+		h.grow()
+	}
+}
+
+// Get will return the item at key, or the zero value of V.
+func (h *TypedMap[K, V]) Get(key K) V {
+	kb := keyBytes(&key)
+	hk := h.Hash(kb)
+	for e := h.bkts[hk&h.msk]; e != nil; e = e.next {
+		if len(kb) == len(e.key) && hk == e.hk && SilceEqui(kb, keyBytes(&e.key)) {
+			return e.data
+		}
+	}
+	var zero V
+	return zero
+}
+
+// Lookup will return the item at key and whether it was found.
+func (h *TypedMap[K, V]) Lookup(key K) (V, bool) {
+	kb := keyBytes(&key)
+	hk := h.Hash(kb)
+	for e := h.bkts[hk&h.msk]; e != nil; e = e.next {
+		if len(kb) == len(e.key) && hk == e.hk && SilceEqui(kb, keyBytes(&e.key)) {
+			return e.data, true
+		}
+	}
+	var zero V
+	return zero, false
+}
+
+// Contains reports whether key is present in the TypedMap.
+func (h *TypedMap[K, V]) Contains(key K) bool {
+	_, ok := h.Lookup(key)
+	return ok
+}
+
+// Remove will remove what is associated with key. It returns the
+// removed item and whether anything was removed.
+func (h *TypedMap[K, V]) Remove(key K) (V, bool) {
+	kb := keyBytes(&key)
+	hk := h.Hash(kb)
+	e := &h.bkts[hk&h.msk]
+	for *e != nil {
+		if len(kb) == len((*e).key) && hk == (*e).hk && bytes.Equal(kb, keyBytes(&(*e).key)) {
+			// Success
+			data := (*e).data
+			*e = (*e).next
+			h.used -= 1
+			// Check for resizing
+			lbkts := uint32(len(h.bkts))
+			if h.rsz && lbkts > _BSZ && (h.used < lbkts>>2) {
+				h.shrink()
+			}
+			return data, true
+		}
+		e = &(*e).next
+	}
+	var zero V
+	return zero, false
+}
+
+// resize is responsible for reallocating the buckets and
+// redistributing the entries. Entries are relinked, not copied.
+func (h *TypedMap[K, V]) resize(nsz uint32) {
+	nmsk := nsz - 1
+	bkts := make([]*typedEntry[K, V], nsz)
+	for _, e := range h.bkts {
+		for e != nil {
+			ne := e
+			e = e.next
+			ne.next = bkts[ne.hk&nmsk]
+			bkts[ne.hk&nmsk] = ne
+		}
+	}
+	h.bkts = bkts
+	h.msk = nmsk
+}
+
+// grow the TypedMap's buckets by 2
+func (h *TypedMap[K, V]) grow() {
+	// Can't grow beyond maxint for now
+	if len(h.bkts) >= maxBktSize {
+		return
+	}
+	h.resize(uint32(len(h.bkts) << 1))
+}
+
+// shrink the TypedMap's buckets by 2
+func (h *TypedMap[K, V]) shrink() {
+	if len(h.bkts) <= _BSZ {
+		return
+	}
+	h.resize(uint32(len(h.bkts) >> 1))
+}
+
+// Count returns number of elements in the TypedMap
+func (h *TypedMap[K, V]) Count() uint32 {
+	return h.used
+}
+
+// AllKeys will return all the keys stored in the TypedMap
+func (h *TypedMap[K, V]) AllKeys() []K {
+	all := make([]K, 0, h.used)
+	for _, e := range h.bkts {
+		for ; e != nil; e = e.next {
+			all = append(all, e.key)
+		}
+	}
+	return all
+}
+
+// All returns all the values in the TypedMap
+func (h *TypedMap[K, V]) All() []V {
+	all := make([]V, 0, h.used)
+	for _, e := range h.bkts {
+		for ; e != nil; e = e.next {
+			all = append(all, e.data)
+		}
+	}
+	return all
+}
+
+// Stats will collect general statistics about the TypedMap
+func (h *TypedMap[K, V]) Stats() *Stats {
+	lc, totalc, slots := 0, 0, 0
+	for _, e := range h.bkts {
+		if e != nil {
+			slots += 1
+		}
+		i := 0
+		for ; e != nil; e = e.next {
+			i += 1
+			if i > lc {
+				lc = i
+			}
+		}
+		totalc += i
+	}
+	l := uint32(len(h.bkts))
+	avg := (float32(totalc) / float32(slots))
+	return &Stats{
+		NumElements: h.used,
+		NumBuckets:  l,
+		LongChain:   uint32(lc),
+		AvgChain:    avg,
+		NumSlots:    uint32(slots)}
+}
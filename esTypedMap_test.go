@@ -0,0 +1,167 @@
+package esMap
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestTypedMapBasics(t *testing.T) {
+	h := NewTypedMap[string, []byte]()
+
+	if h.Count() != 0 {
+		t.Fatalf("Wrong number of entries: %d vs 0\n", h.Count())
+	}
+	h.Set("foo", bar)
+	if h.Count() != 1 {
+		t.Fatalf("Wrong number of entries: %d vs 1\n", h.Count())
+	}
+	if v := h.Get("foo"); string(v) != string(bar) {
+		t.Fatalf("Did not receive correct answer: '%s' vs '%s'\n", bar, v)
+	}
+	h.Remove("foo")
+	if h.Count() != 0 {
+		t.Fatalf("Wrong number of entries: %d vs 0\n", h.Count())
+	}
+	if v := h.Get("foo"); v != nil {
+		t.Fatal("Did not receive correct answer, should be nil")
+	}
+}
+
+type subject []byte
+
+func TestTypedMapByteKeys(t *testing.T) {
+	h := NewTypedMap[subject, int]()
+	h.Set(subject("foo"), 1)
+	h.Set(subject("foo"), 2)
+	h.Set(subject(""), 3)
+	if h.Count() != 2 {
+		t.Fatalf("Set should replace, expected 2 vs %d\n", h.Count())
+	}
+	if v := h.Get(subject("foo")); v != 2 {
+		t.Fatalf("Value is incorrect, expected 2 vs %d\n", v)
+	}
+	if v := h.Get(subject("")); v != 3 {
+		t.Fatalf("Value is incorrect, expected 3 vs %d\n", v)
+	}
+	// string and []byte keys must hash identically
+	if hs, hb := DefaultHash(keyBytes(new(string))), DefaultHash(keyBytes(new(subject))); hs != hb {
+		t.Fatalf("Empty keys hash differently: %d vs %d\n", hs, hb)
+	}
+	s, b := "foo.bar.baz", []byte("foo.bar.baz")
+	if DefaultHash(keyBytes(&s)) != DefaultHash(keyBytes(&b)) {
+		t.Fatalf("String and slice keys hash differently\n")
+	}
+}
+
+func TestTypedMapLookup(t *testing.T) {
+	h := NewTypedMap[string, *int]()
+	h.Set("foo", nil)
+	if v, ok := h.Lookup("foo"); !ok || v != nil {
+		t.Fatalf("Expected stored nil to be found, got %v, %v\n", v, ok)
+	}
+	if h.Contains("bar") {
+		t.Fatalf("Expected Contains(bar) to be false\n")
+	}
+	if _, ok := h.Remove("foo"); !ok {
+		t.Fatalf("Expected to remove foo\n")
+	}
+	if _, ok := h.Remove("foo"); ok {
+		t.Fatalf("Expected nothing to remove\n")
+	}
+}
+
+func TestTypedMapGrowShrink(t *testing.T) {
+	h := NewTypedMap[string, string]()
+
+	var toks [INS]string
+	for i := range toks {
+		u := make([]byte, 13)
+		io.ReadFull(rand.Reader, u)
+		toks[i] = hex.EncodeToString(u)
+		h.Set(toks[i], toks[i])
+		if tg := h.Get(toks[i]); tg != toks[i] {
+			t.Fatalf("Did not match properly, '%s' vs '%s'\n", tg, toks[i])
+		}
+	}
+	if len(h.bkts) != EXP {
+		t.Fatalf("Expanded bucket size is wrong: %d vs %d\n", len(h.bkts), EXP)
+	}
+	s := h.Stats()
+	if s.NumElements != INS || s.NumBuckets != EXP {
+		t.Fatalf("Stats incorrect: %+v\n", s)
+	}
+	if len(h.AllKeys()) != INS || len(h.All()) != INS {
+		t.Fatalf("Expected %d keys and values\n", INS)
+	}
+	for i := 0; i < REM; i++ {
+		h.Remove(toks[i])
+	}
+	if len(h.bkts) != EXP2 {
+		t.Fatalf("Shrunk bucket size is wrong: %d vs %d\n", len(h.bkts), EXP2)
+	}
+	for i := REM; i < INS; i++ {
+		if tg := h.Get(toks[i]); tg != toks[i] {
+			t.Fatalf("Did not match properly, '%s' vs '%s'\n", tg, toks[i])
+		}
+	}
+}
+
+func Benchmark_TypedMap_GetSmallKey_1024(b *testing.B) {
+	size := 1024
+	m := NewTypedMap[string, []byte]()
+	keys := make([]string, size)
+	for i := 0; i < len(keys); i++ {
+		keys[i] = fmt.Sprintf("foo.%d", i)
+		m.Set(keys[i], bar)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	Grp := b.N / size
+	for g := 0; g < Grp; g++ {
+		for i := 0; i < size; i++ {
+			_ = m.Get(keys[i])
+		}
+	}
+}
+
+func Benchmark_TypedMap_______Set(b *testing.B) {
+	size := 10000
+	m := NewTypedMap[string, int]()
+	keys := make([]string, size)
+	for i := 0; i < len(keys); i++ {
+		keys[i] = fmt.Sprintf("foo.%d", i)
+		m.Set(keys[i], i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	Grp := b.N / size
+	for g := 0; g < Grp; g++ {
+		for i := 0; i < size; i++ {
+			m.Set(keys[i], i)
+		}
+	}
+}
+
+func Benchmark_HashMap____SetInt(b *testing.B) {
+	size := 10000
+	m := NewHashMap()
+	keys := make([][]byte, size)
+	for i := 0; i < len(keys); i++ {
+		keys[i] = []byte(fmt.Sprintf("foo.%d", i))
+		m.Set(keys[i], i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	Grp := b.N / size
+	for g := 0; g < Grp; g++ {
+		for i := 0; i < size; i++ {
+			m.Set(keys[i], i+1000)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/yireyun/go-map
+
+go 1.23
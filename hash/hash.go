@@ -0,0 +1,267 @@
+// Package hash provides fast non-cryptographic hash functions
+// suitable for use as esMap.HashMap.Hash. Every function has the
+// signature func([]byte) uint32 so they can be swapped freely, and
+// a seeded variant which Seeded turns into such a function.
+//
+// A random seed stops some collisions computed offline against the
+// unseeded functions, but none of these functions is a keyed hash:
+// collisions that come from their structure survive any seed, and an
+// attacker who can observe the map may find others. Use hash/maphash
+// where keys come from untrusted sources.
+package hash
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// Wukehong is the default hash of esMap. It is a Meiyan style
+// word-at-a-time hash that consumes 8 bytes per round and is very
+// fast for the short, dotted subjects typically used as keys.
+func Wukehong(data []byte) uint32 {
+	return WukehongSeed(data, 0)
+}
+
+// WukehongSeed is Wukehong starting from seed. A round only depends
+// on rotl(k1, 5) ^ k2 of its two words, so keys sharing that value
+// collide whatever the seed.
+func WukehongSeed(data []byte, seed uint32) uint32 {
+	const prime = 0x000ad3e7
+	h32 := uint32(2166136261) ^ seed
+	i, dlen := 0, len(data)
+	for ; dlen >= 8; dlen, i = dlen-8, i+8 {
+		k1 := binary.LittleEndian.Uint32(data[i:])
+		k2 := binary.LittleEndian.Uint32(data[i+4:])
+		h32 = (h32 ^ (bits.RotateLeft32(k1, 5) ^ k2)) * prime
+	}
+	// Cases: 0,1,2,3,4,5,6,7
+	if dlen&4 != 0 {
+		k1 := binary.LittleEndian.Uint16(data[i:])
+		k2 := binary.LittleEndian.Uint16(data[i+2:])
+		h32 = (h32 ^ uint32(k1)) * prime
+		h32 = (h32 ^ uint32(k2)) * prime
+		i += 4
+	}
+	if dlen&2 != 0 {
+		k1 := binary.LittleEndian.Uint16(data[i:])
+		h32 = (h32 ^ uint32(k1)) * prime
+		i += 2
+	}
+	if dlen&1 != 0 {
+		h32 = (h32 ^ uint32(data[i])) * prime
+	}
+	return h32 ^ (h32 >> 16)
+}
+
+// FNV1a is the 32 bit Fowler-Noll-Vo 1a hash.
+func FNV1a(data []byte) uint32 {
+	return FNV1aSeed(data, 0)
+}
+
+// FNV1aSeed is FNV1a with seed mixed into its offset basis.
+func FNV1aSeed(data []byte, seed uint32) uint32 {
+	const (
+		offset32 = 2166136261
+		prime32  = 16777619
+	)
+	h32 := uint32(offset32) ^ seed
+	for _, c := range data {
+		h32 ^= uint32(c)
+		h32 *= prime32
+	}
+	return h32
+}
+
+// Jenkins is Bob Jenkins' one-at-a-time hash.
+func Jenkins(data []byte) uint32 {
+	return JenkinsSeed(data, 0)
+}
+
+// JenkinsSeed is Jenkins starting from seed.
+func JenkinsSeed(data []byte, seed uint32) uint32 {
+	h32 := seed
+	for _, c := range data {
+		h32 += uint32(c)
+		h32 += h32 << 10
+		h32 ^= h32 >> 6
+	}
+	h32 += h32 << 3
+	h32 ^= h32 >> 11
+	h32 += h32 << 15
+	return h32
+}
+
+// Murmur3 is the x86 32 bit variant of Austin Appleby's MurmurHash3
+// with a seed of 0.
+func Murmur3(data []byte) uint32 {
+	return Murmur3Seed(data, 0)
+}
+
+// Murmur3Seed is the x86 32 bit variant of MurmurHash3 with seed.
+func Murmur3Seed(data []byte, seed uint32) uint32 {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+	)
+	h32 := seed
+	i, dlen := 0, len(data)
+	for ; dlen >= 4; dlen, i = dlen-4, i+4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h32 ^= k
+		h32 = bits.RotateLeft32(h32, 13)
+		h32 = h32*5 + 0xe6546b64
+	}
+	var k uint32
+	switch dlen {
+	case 3:
+		k ^= uint32(data[i+2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(data[i+1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(data[i])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h32 ^= k
+	}
+	h32 ^= uint32(len(data))
+	return fmix32(h32)
+}
+
+// fmix32 is the Murmur3 finalizer, forcing all bits of h to avalanche.
+func fmix32(h uint32) uint32 {
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+// XXHash32 is Yann Collet's xxHash, 32 bit variant, with a seed of 0.
+func XXHash32(data []byte) uint32 {
+	return XXHash32Seed(data, 0)
+}
+
+// XXHash32Seed is the 32 bit variant of xxHash with seed.
+func XXHash32Seed(data []byte, seed uint32) uint32 {
+	const (
+		p1 = 2654435761
+		p2 = 2246822519
+		p3 = 3266489917
+		p4 = 668265263
+		p5 = 374761393
+	)
+	var h32 uint32
+	i, dlen := 0, len(data)
+	if dlen >= 16 {
+		v1 := seed + p1 + p2
+		v2 := seed + p2
+		v3 := seed
+		v4 := seed - p1
+		round := func(v, k uint32) uint32 {
+			return bits.RotateLeft32(v+k*p2, 13) * p1
+		}
+		for ; dlen >= 16; dlen, i = dlen-16, i+16 {
+			v1 = round(v1, binary.LittleEndian.Uint32(data[i:]))
+			v2 = round(v2, binary.LittleEndian.Uint32(data[i+4:]))
+			v3 = round(v3, binary.LittleEndian.Uint32(data[i+8:]))
+			v4 = round(v4, binary.LittleEndian.Uint32(data[i+12:]))
+		}
+		h32 = bits.RotateLeft32(v1, 1) + bits.RotateLeft32(v2, 7) +
+			bits.RotateLeft32(v3, 12) + bits.RotateLeft32(v4, 18)
+	} else {
+		h32 = seed + p5
+	}
+	h32 += uint32(len(data))
+	for ; dlen >= 4; dlen, i = dlen-4, i+4 {
+		h32 += binary.LittleEndian.Uint32(data[i:]) * p3
+		h32 = bits.RotateLeft32(h32, 17) * p4
+	}
+	for ; dlen > 0; dlen, i = dlen-1, i+1 {
+		h32 += uint32(data[i]) * p5
+		h32 = bits.RotateLeft32(h32, 11) * p1
+	}
+	h32 ^= h32 >> 15
+	h32 *= p2
+	h32 ^= h32 >> 13
+	h32 *= p3
+	h32 ^= h32 >> 16
+	return h32
+}
+
+// XXHash64 is Yann Collet's xxHash, 64 bit variant, with a seed of 0.
+func XXHash64(data []byte) uint64 {
+	return XXHash64Seed(data, 0)
+}
+
+// XXHash64Seed is the 64 bit variant of xxHash with seed.
+func XXHash64Seed(data []byte, seed uint64) uint64 {
+	const (
+		p1 = 11400714785074694791
+		p2 = 14029467366897019727
+		p3 = 1609587929392839161
+		p4 = 9650029242287828579
+		p5 = 2870177450012600261
+	)
+	round := func(v, k uint64) uint64 {
+		return bits.RotateLeft64(v+k*p2, 31) * p1
+	}
+	var h64 uint64
+	i, dlen := 0, len(data)
+	if dlen >= 32 {
+		v1 := seed + p1 + p2
+		v2 := seed + p2
+		v3 := seed
+		v4 := seed - p1
+		for ; dlen >= 32; dlen, i = dlen-32, i+32 {
+			v1 = round(v1, binary.LittleEndian.Uint64(data[i:]))
+			v2 = round(v2, binary.LittleEndian.Uint64(data[i+8:]))
+			v3 = round(v3, binary.LittleEndian.Uint64(data[i+16:]))
+			v4 = round(v4, binary.LittleEndian.Uint64(data[i+24:]))
+		}
+		h64 = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) +
+			bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		for _, v := range [4]uint64{v1, v2, v3, v4} {
+			h64 ^= round(0, v)
+			h64 = h64*p1 + p4
+		}
+	} else {
+		h64 = seed + p5
+	}
+	h64 += uint64(len(data))
+	for ; dlen >= 8; dlen, i = dlen-8, i+8 {
+		h64 ^= round(0, binary.LittleEndian.Uint64(data[i:]))
+		h64 = bits.RotateLeft64(h64, 27)*p1 + p4
+	}
+	if dlen >= 4 {
+		h64 ^= uint64(binary.LittleEndian.Uint32(data[i:])) * p1
+		h64 = bits.RotateLeft64(h64, 23)*p2 + p3
+		dlen, i = dlen-4, i+4
+	}
+	for ; dlen > 0; dlen, i = dlen-1, i+1 {
+		h64 ^= uint64(data[i]) * p5
+		h64 = bits.RotateLeft64(h64, 11) * p1
+	}
+	h64 ^= h64 >> 33
+	h64 *= p2
+	h64 ^= h64 >> 29
+	h64 *= p3
+	h64 ^= h64 >> 32
+	return h64
+}
+
+// SeededFunc is a hash function taking a seed.
+type SeededFunc func(data []byte, seed uint32) uint32
+
+// Seeded returns the hash function f with its seed fixed to seed.
+func Seeded(f SeededFunc, seed uint32) func([]byte) uint32 {
+	return func(data []byte) uint32 {
+		return f(data, seed)
+	}
+}
@@ -0,0 +1,167 @@
+package hash
+
+import (
+	"fmt"
+	"testing"
+)
+
+type vector struct {
+	in  string
+	out uint32
+}
+
+func testVectors(t *testing.T, name string, f func([]byte) uint32, vs []vector) {
+	for _, v := range vs {
+		if h := f([]byte(v.in)); h != v.out {
+			t.Fatalf("%s(%q) = 0x%08x, expected 0x%08x\n", name, v.in, h, v.out)
+		}
+	}
+}
+
+func TestFNV1a(t *testing.T) {
+	testVectors(t, "FNV1a", FNV1a, []vector{
+		{"", 0x811c9dc5},
+		{"a", 0xe40c292c},
+		{"foobar", 0xbf9cf968},
+	})
+}
+
+func TestJenkins(t *testing.T) {
+	testVectors(t, "Jenkins", Jenkins, []vector{
+		{"a", 0xca2e9442},
+		{"The quick brown fox jumps over the lazy dog", 0x519e91f5},
+	})
+}
+
+func TestMurmur3(t *testing.T) {
+	testVectors(t, "Murmur3", Murmur3, []vector{
+		{"", 0x00000000},
+		{"hello", 0x248bfa47},
+		{"The quick brown fox jumps over the lazy dog", 0x2e4ff723},
+	})
+}
+
+func TestXXHash32(t *testing.T) {
+	testVectors(t, "XXHash32", XXHash32, []vector{
+		{"", 0x02cc5d05},
+		{"a", 0x550d7456},
+		{"Nobody inspects the spammish repetition", 0xe2293b2f},
+	})
+}
+
+func TestXXHash64(t *testing.T) {
+	vs := []struct {
+		in  string
+		out uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"a", 0xd24ec4f1a98c6e5b},
+		{"abc", 0x44bc2cf5ad770999},
+		{"Nobody inspects the spammish repetition", 0xfbcea83c8a378bf1},
+	}
+	for _, v := range vs {
+		if h := XXHash64([]byte(v.in)); h != v.out {
+			t.Fatalf("XXHash64(%q) = 0x%016x, expected 0x%016x\n", v.in, h, v.out)
+		}
+	}
+}
+
+func TestHasher(t *testing.T) {
+	hashers := map[string]Hasher{
+		"Func32":    Func32(Murmur3),
+		"XXH64":     XXH64(0),
+		"MapHasher": NewMapHasher(),
+	}
+	buf := []byte("apcera.continuum.router.foo.bar.baz")
+	for name, hs := range hashers {
+		seen := make(map[uint64]int)
+		for i := 0; i <= len(buf); i++ {
+			h := hs.Sum64(buf[:i:i])
+			if j, ok := seen[h]; ok {
+				t.Fatalf("%s collides for lengths %d and %d\n", name, j, i)
+			}
+			seen[h] = i
+			if hs.Sum64(buf[:i]) != h {
+				t.Fatalf("%s is not deterministic\n", name)
+			}
+		}
+	}
+	if Func32(Murmur3).Sum32(buf) != Murmur3(buf) {
+		t.Fatalf("Func32 should return the 32 bit hash\n")
+	}
+	if XXH64(0).Sum64(buf) != XXHash64(buf) {
+		t.Fatalf("XXH64 should return XXHash64\n")
+	}
+	if s := XXH64(0).WithSeed(1); s.Sum64(buf) != XXHash64Seed(buf, 1) || s.Sum64(buf) == XXHash64(buf) {
+		t.Fatalf("XXH64 ignores its seed\n")
+	}
+}
+
+// All functions must cope with every tail length without reading
+// past the end of the slice.
+func TestTailLengths(t *testing.T) {
+	funcs := map[string]func([]byte) uint32{
+		"Wukehong": Wukehong,
+		"FNV1a":    FNV1a,
+		"Jenkins":  Jenkins,
+		"Murmur3":  Murmur3,
+		"XXHash32": XXHash32,
+	}
+	buf := []byte("apcera.continuum.router.foo.bar.baz")
+	for name, f := range funcs {
+		seen := make(map[uint32]int)
+		for i := 0; i <= len(buf); i++ {
+			h := f(buf[:i:i])
+			if j, ok := seen[h]; ok {
+				t.Fatalf("%s collides for lengths %d and %d\n", name, j, i)
+			}
+			seen[h] = i
+		}
+	}
+}
+
+func TestSeeded(t *testing.T) {
+	funcs := map[string][2]interface{}{
+		"Wukehong": {Wukehong, WukehongSeed},
+		"FNV1a":    {FNV1a, FNV1aSeed},
+		"Jenkins":  {Jenkins, JenkinsSeed},
+		"Murmur3":  {Murmur3, Murmur3Seed},
+		"XXHash32": {XXHash32, XXHash32Seed},
+	}
+	for name, fs := range funcs {
+		f, sf := fs[0].(func([]byte) uint32), SeededFunc(fs[1].(func([]byte, uint32) uint32))
+		for i := 0; i <= len(bench); i++ {
+			if f(bench[:i]) != Seeded(sf, 0)(bench[:i]) {
+				t.Fatalf("%s with seed 0 should be the unseeded hash\n", name)
+			}
+		}
+		if sf(bench, 1) == sf(bench, 2) {
+			t.Fatalf("%s ignores its seed\n", name)
+		}
+	}
+	// Known answer for a seeded Murmur3
+	if h := Murmur3Seed([]byte("hello"), 1); h != 0xbb4abcad {
+		t.Fatalf("Murmur3Seed = 0x%08x, expected 0xbb4abcad\n", h)
+	}
+}
+
+var bench = []byte("apcera.continuum.router.foo.bar.baz")
+
+func benchmarkHash(b *testing.B, f func([]byte) uint32) {
+	b.SetBytes(int64(len(bench)))
+	for i := 0; i < b.N; i++ {
+		f(bench)
+	}
+}
+
+func Benchmark_Wukehong(b *testing.B) { benchmarkHash(b, Wukehong) }
+func Benchmark_FNV1a___(b *testing.B) { benchmarkHash(b, FNV1a) }
+func Benchmark_Jenkins_(b *testing.B) { benchmarkHash(b, Jenkins) }
+func Benchmark_Murmur3_(b *testing.B) { benchmarkHash(b, Murmur3) }
+func Benchmark_XXHash32(b *testing.B) { benchmarkHash(b, XXHash32) }
+func Benchmark_XXHash64(b *testing.B) { benchmarkHash(b, XXH64(0).Sum32) }
+
+func ExampleFNV1a() {
+	fmt.Printf("0x%08x\n", FNV1a([]byte("a")))
+	// Output: 0xe40c292c
+}
@@ -0,0 +1,94 @@
+package hash
+
+import "hash/maphash"
+
+// Hasher is a hash function with 32 and 64 bit output. Maps hashing
+// with Sum64 can store more hash bits per entry, which rules out
+// nearly every false key comparison and allows for more buckets.
+type Hasher interface {
+	Sum32(data []byte) uint32
+	Sum64(data []byte) uint64
+}
+
+// SeededHasher is a Hasher that can be seeded.
+type SeededHasher interface {
+	Hasher
+	// WithSeed returns the same hash function with seed.
+	WithSeed(seed uint64) Hasher
+}
+
+var (
+	_ Hasher       = Func32(nil)
+	_ SeededHasher = XXH64(0)
+	_ Hasher       = MapHasher{}
+)
+
+// Func32 makes a Hasher of a 32 bit hash function. Sum64 spreads the
+// 32 bits over 64 bits, it does not add any entropy.
+type Func32 func([]byte) uint32
+
+// Sum32 returns f(data).
+func (f Func32) Sum32(data []byte) uint32 {
+	return f(data)
+}
+
+// Sum64 returns f(data) mixed into 64 bits.
+func (f Func32) Sum64(data []byte) uint64 {
+	return fmix64(uint64(f(data)))
+}
+
+// fmix64 is the 64 bit finalizer of MurmurHash3. It is a bijection
+// whose output bits all depend on every input bit.
+func fmix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// XXH64 is a SeededHasher using XXHash64 with the seed it holds.
+type XXH64 uint64
+
+// Sum32 returns the 64 bit hash folded to 32 bits.
+func (x XXH64) Sum32(data []byte) uint32 {
+	return fold(x.Sum64(data))
+}
+
+// Sum64 returns XXHash64Seed(data, x).
+func (x XXH64) Sum64(data []byte) uint64 {
+	return XXHash64Seed(data, uint64(x))
+}
+
+// WithSeed returns XXH64(seed).
+func (x XXH64) WithSeed(seed uint64) Hasher {
+	return XXH64(seed)
+}
+
+// MapHasher is a Hasher using hash/maphash. Unlike the other
+// functions of the package it is a keyed hash, so it resists hash
+// flooding by keys from untrusted sources.
+type MapHasher struct {
+	seed maphash.Seed
+}
+
+// NewMapHasher returns a MapHasher with a random seed.
+func NewMapHasher() MapHasher {
+	return MapHasher{maphash.MakeSeed()}
+}
+
+// Sum32 returns the 64 bit hash folded to 32 bits.
+func (m MapHasher) Sum32(data []byte) uint32 {
+	return fold(m.Sum64(data))
+}
+
+// Sum64 returns maphash.Bytes of data.
+func (m MapHasher) Sum64(data []byte) uint64 {
+	return maphash.Bytes(m.seed, data)
+}
+
+// fold folds a 64 bit hash to 32 bits.
+func fold(h uint64) uint32 {
+	return uint32(h ^ h>>32)
+}
@@ -0,0 +1,46 @@
+// Idents prints the identifiers of the Go package in the directory
+// given as argument, one per line in source order, skipping tests.
+// It recorded the trace of the hit ratio tests:
+//
+//	go run testdata/idents.go $(go env GOROOT)/src/net/http | gzip -9 -n > testdata/nethttp.trace.gz
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	files, err := filepath.Glob(filepath.Join(os.Args[1], "*.go"))
+	if err != nil {
+		panic(err)
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	fset := token.NewFileSet()
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		src, err := os.ReadFile(name)
+		if err != nil {
+			panic(err)
+		}
+		var s scanner.Scanner
+		s.Init(fset.AddFile(name, -1, len(src)), src, nil, 0)
+		for {
+			_, tok, lit := s.Scan()
+			if tok == token.EOF {
+				break
+			}
+			if tok == token.IDENT {
+				fmt.Fprintln(w, lit)
+			}
+		}
+	}
+}